@@ -0,0 +1,104 @@
+// Command indexcheck verifies, and optionally repairs, the consistency between the objects of a table and their index entries.
+//
+// Usage:
+//
+//	indexcheck -tenant <tenant_id> -table <table_hash> [-repair] [-orphans=false]
+//
+// It reads the same AWS environment variables as the API server (AWS_REGION, DYNAMO_OBJECTS_TABLE, DYNAMO_INDEXES_TABLE, USE_LOCALSTACK, LOCALSTACK_URL)
+// and prints the report as JSON on stdout. The exit code is 1 if any issue was found and left unrepaired.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+)
+
+func main() {
+	tenantId := flag.String("tenant", "", "Tenant ID")
+	tableHash := flag.String("table", "", "Table hash")
+	repair := flag.Bool("repair", false, "Delete orphaned and dangling index entries and rewrite stale S3 keys")
+	scanOrphans := flag.Bool("orphans", true, "Scan the whole indexes table for entries whose object no longer exists")
+	timeout := flag.Duration("timeout", 30*time.Minute, "Maximum duration of the check")
+	flag.Parse()
+
+	if *tenantId == "" || *tableHash == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	useLocalstack := getEnvAsBool("USE_LOCALSTACK", false)
+	localstackUrl := getEnv("LOCALSTACK_URL", "http://localhost:4566")
+	configOpts := []func(*config.LoadOptions) error{
+		config.WithRegion(getEnv("AWS_REGION", "eu-central-1")),
+	}
+	if useLocalstack {
+		configOpts = append(configOpts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, configOpts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load AWS config: %v\n", err)
+		os.Exit(1)
+	}
+
+	dynamoClient := storage.NewDynamoClient(ctx,
+		getEnvOrExit("DYNAMO_OBJECTS_TABLE"),
+		getEnvOrExit("DYNAMO_INDEXES_TABLE"),
+		os.Getenv("DYNAMO_TABLE_CONFIGS_TABLE"),
+		os.Getenv("DYNAMO_TENANT_CONFIGS_TABLE"),
+		os.Getenv("DYNAMO_API_KEYS_TABLE"),
+		os.Getenv("DYNAMO_OUTBOX_TABLE"),
+		os.Getenv("DYNAMO_IDEMPOTENCY_TABLE"),
+		cfg, useLocalstack, localstackUrl)
+
+	report, err := dynamoClient.VerifyIndexes(ctx, *tenantId, *tableHash, *repair, *scanOrphans)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify indexes: %v\n", err)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode report: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(report.Issues) > report.Repaired {
+		os.Exit(1)
+	}
+}
+
+func getEnv(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+func getEnvOrExit(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	fmt.Fprintf(os.Stderr, "environment variable %s is required\n", key)
+	os.Exit(2)
+	return ""
+}
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
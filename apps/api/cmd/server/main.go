@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"expvar"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,8 +17,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/qodesrl/gardbase/apps/api/internal/handlers"
 	"github.com/qodesrl/gardbase/apps/api/internal/middleware"
+	"github.com/qodesrl/gardbase/apps/api/internal/pagination"
 	"github.com/qodesrl/gardbase/apps/api/internal/services"
 	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/apps/api/internal/workers"
 	"github.com/qodesrl/gardbase/pkg/models"
 	"go.uber.org/zap"
 )
@@ -30,6 +34,7 @@ type Server struct {
 type Config struct {
 	Port        string
 	Environment string
+	MetricsAddr string // internal listener for /metrics, disabled if empty
 }
 
 type AWSConfig struct {
@@ -40,6 +45,8 @@ type AWSConfig struct {
 	DynamoTableConfigsTable  string
 	DynamoTenantConfigsTable string
 	DynamoAPIKeysTable       string
+	DynamoOutboxTable        string
+	DynamoIdempotencyTable   string
 	KMSKeyID                 string
 	MaxRetries               int
 	RequestTimeout           time.Duration
@@ -63,6 +70,11 @@ func main() {
 		logger.Fatal("Failed to initialize storage clients", zap.Error(err))
 	}
 
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	outboxWorker := workers.NewOutboxWorker(dynamoClient, s3Client, logger, time.Duration(getEnvAsInt("OUTBOX_POLL_INTERVAL", 10))*time.Second)
+	outboxWorker.Start(workerCtx)
+
 	server.setupRoutes(s3Client, dynamoClient, kmsService)
 	server.start()
 }
@@ -118,7 +130,11 @@ func (s *Server) setupRoutes(s3Client *storage.S3Client, dynamoClient *storage.D
 		Dynamo:     dynamoClient,
 		KMS:        kmsService,
 		PresignTTL: 15 * time.Minute,
+		Pagination: loadPaginationCodec(s.logger, s.config.Environment),
 	}
+	// the reservation must outlive the presigned URL, otherwise an upload finished at the last moment cannot be confirmed
+	objectHandler.UploadReservationTTL = max(time.Duration(getEnvAsInt("UPLOAD_RESERVATION_TTL", 3600))*time.Second, objectHandler.PresignTTL)
+	objectHandler.ExportCheckpointTTL = time.Duration(getEnvAsInt("EXPORT_CHECKPOINT_TTL", 7*24*3600)) * time.Second
 	objects := api.Group("/objects")
 	objects.Use(middleware.TenantMiddleware(dynamoClient))
 	objects.POST("/get-table-hash", middleware.PermissionMiddleware([]string{models.PermissionRead, models.PermissionWrite}), objectHandler.GetTableHash)
@@ -128,16 +144,49 @@ func (s *Server) setupRoutes(s3Client *storage.S3Client, dynamoClient *storage.D
 		readGroup.POST("/get", objectHandler.Get)
 		readGroup.POST("/scan", objectHandler.Scan)
 		readGroup.POST("/query", objectHandler.Query)
+		readGroup.POST("/export", objectHandler.Export)
+		readGroup.POST("/batch-get", objectHandler.BatchGet)
+		readGroup.POST("/presign", objectHandler.Presign)
+		readGroup.POST("/versions", objectHandler.ListVersions)
+		readGroup.POST("/trash/list", objectHandler.TrashList)
+	}
+	if getEnvAsBool("BLOB_PROXY_ENABLED", false) {
+		// for networks that cannot reach S3; outside of the write group, whose idempotency middleware buffers request bodies
+		objects.GET("/blob/:id", middleware.PermissionMiddleware([]string{models.PermissionRead}), objectHandler.GetBlob)
+		objects.PUT("/blob/:id", middleware.PermissionMiddleware([]string{models.PermissionWrite}), objectHandler.PutBlob)
 	}
 	writeGroup := objects.Group("/")
 	writeGroup.Use(middleware.PermissionMiddleware([]string{models.PermissionWrite}))
+	writeGroup.Use(middleware.IdempotencyMiddleware(dynamoClient, time.Duration(getEnvAsInt("IDEMPOTENCY_KEY_TTL", 86400))*time.Second, s.logger))
 	{
 		writeGroup.POST("/put", objectHandler.Put)
 		writeGroup.POST("/request-put-large", objectHandler.RequestPutLarge)
 		writeGroup.POST("/confirm-put-large", objectHandler.ConfirmPutLarge)
+		writeGroup.POST("/resume-put-large", objectHandler.ResumePutLarge)
+		writeGroup.POST("/abort-put-large", objectHandler.AbortPutLarge)
+		writeGroup.POST("/put-indexes", objectHandler.PutIndexes)
 		writeGroup.POST("/delete", objectHandler.Delete)
 		writeGroup.POST("/recover", objectHandler.Recover)
+		writeGroup.POST("/batch-put", objectHandler.BatchPut)
+		writeGroup.POST("/batch-delete", objectHandler.BatchDelete)
+		writeGroup.POST("/transact", objectHandler.Transact)
+		writeGroup.POST("/restore-version", objectHandler.RestoreVersion)
+		writeGroup.POST("/trash/recover", objectHandler.TrashRecover)
+		writeGroup.POST("/trash/purge", objectHandler.TrashPurge)
+	}
+
+	adminHandler := &handlers.AdminHandler{
+		Dynamo:   dynamoClient,
+		S3Client: s3Client,
 	}
+	adminGroup := api.Group("/admin")
+	adminGroup.Use(middleware.TenantMiddleware(dynamoClient))
+	adminGroup.Use(middleware.PermissionMiddleware([]string{models.PermissionAdmin}))
+	adminGroup.POST("/verify-indexes", adminHandler.VerifyIndexes)
+	adminGroup.POST("/index-shards", adminHandler.SetIndexShards)
+	adminGroup.POST("/history-retention", adminHandler.SetHistoryRetention)
+	adminGroup.POST("/soft-delete-retention", adminHandler.SetSoftDeleteRetention)
+	adminGroup.POST("/reconcile-blobs", adminHandler.ReconcileBlobs)
 
 	encryptionHandler := &handlers.EncryptionHandler{
 		Vsock:  vsock,
@@ -160,6 +209,23 @@ func (s *Server) start() {
 		Handler: s.router,
 	}
 
+	// the metrics cover every tenant, so they are served on a separate listener that is not exposed with the API
+	var metricsSrv *http.Server
+	if s.config.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", expvar.Handler())
+		metricsSrv = &http.Server{
+			Addr:    s.config.MetricsAddr,
+			Handler: mux,
+		}
+		go func() {
+			s.logger.Info("Starting metrics server", zap.String("addr", s.config.MetricsAddr))
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("Failed to start metrics server", zap.Error(err))
+			}
+		}()
+	}
+
 	// start server in a goroutine
 	go func() {
 		s.logger.Info("Starting Gardbase API server",
@@ -181,6 +247,9 @@ func (s *Server) start() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if metricsSrv != nil {
+		_ = metricsSrv.Shutdown(ctx)
+	}
 	if err := srv.Shutdown(ctx); err != nil {
 		s.logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
@@ -197,7 +266,7 @@ func initAWSServices(ctx context.Context, logger *zap.Logger) (*storage.S3Client
 	}
 
 	s3Client := storage.NewS3Client(ctx, awsConfig.S3Bucket, cfg, awsConfig.UseLocalstack, awsConfig.LocalstackUrl)
-	dynamoClient := storage.NewDynamoClient(ctx, awsConfig.DynamoObjectsTable, awsConfig.DynamoIndexesTable, awsConfig.DynamoTableConfigsTable, awsConfig.DynamoTenantConfigsTable, awsConfig.DynamoAPIKeysTable, cfg, awsConfig.UseLocalstack, awsConfig.LocalstackUrl)
+	dynamoClient := storage.NewDynamoClient(ctx, awsConfig.DynamoObjectsTable, awsConfig.DynamoIndexesTable, awsConfig.DynamoTableConfigsTable, awsConfig.DynamoTenantConfigsTable, awsConfig.DynamoAPIKeysTable, awsConfig.DynamoOutboxTable, awsConfig.DynamoIdempotencyTable, cfg, awsConfig.UseLocalstack, awsConfig.LocalstackUrl)
 	kmsService := services.NewKMSService(ctx, cfg, awsConfig.KMSKeyID, awsConfig.UseLocalstack, awsConfig.LocalstackUrl)
 
 	if err := testAWSConnectivity(ctx, s3Client, dynamoClient, logger); err != nil {
@@ -211,6 +280,7 @@ func loadConfig() *Config {
 	config := &Config{
 		Port:        getEnv("PORT", "80"),
 		Environment: getEnv("ENVIRONMENT", "development"),
+		MetricsAddr: getEnv("METRICS_ADDR", "127.0.0.1:9090"),
 	}
 
 	return config
@@ -225,6 +295,8 @@ func loadAWSConfig() *AWSConfig {
 		DynamoTableConfigsTable:  getEnvOrPanic("DYNAMO_TABLE_CONFIGS_TABLE"),
 		DynamoTenantConfigsTable: getEnvOrPanic("DYNAMO_TENANT_CONFIGS_TABLE"),
 		DynamoAPIKeysTable:       getEnvOrPanic("DYNAMO_API_KEYS_TABLE"),
+		DynamoOutboxTable:        getEnvOrPanic("DYNAMO_OUTBOX_TABLE"),
+		DynamoIdempotencyTable:   getEnvOrPanic("DYNAMO_IDEMPOTENCY_TABLE"),
 		KMSKeyID:                 getEnvOrPanic("KMS_KEY_ID"),
 		MaxRetries:               getEnvAsInt("AWS_MAX_RETRIES", 3),
 		RequestTimeout:           time.Duration(getEnvAsInt("AWS_REQUEST_TIMEOUT", 5)) * time.Second,
@@ -233,6 +305,24 @@ func loadAWSConfig() *AWSConfig {
 	}
 }
 
+func loadPaginationCodec(logger *zap.Logger, environment string) *pagination.Codec {
+	secret := []byte(os.Getenv("PAGINATION_TOKEN_SECRET"))
+	if len(secret) == 0 {
+		if environment == "production" {
+			logger.Fatal("environment variable PAGINATION_TOKEN_SECRET is required in production")
+		}
+		// tokens issued with a random secret do not survive a restart and are not accepted by other instances
+		secret = make([]byte, 32)
+		rand.Read(secret)
+		logger.Warn("PAGINATION_TOKEN_SECRET is not set, using a random secret")
+	}
+	codec, err := pagination.NewCodec(secret, getEnvAsBool("PAGINATION_TOKEN_ENCRYPT", true), time.Duration(getEnvAsInt("PAGINATION_TOKEN_TTL", 3600))*time.Second)
+	if err != nil {
+		logger.Fatal("Failed to initialize pagination tokens", zap.Error(err))
+	}
+	return codec
+}
+
 func loadAWSSDKConfig(ctx context.Context, awsConfig *AWSConfig) (aws.Config, error) {
 	var configOpts []func(*config.LoadOptions) error
 
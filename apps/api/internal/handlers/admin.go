@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/pkg/api/admin"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+type AdminHandler struct {
+	Dynamo   *storage.DynamoClient
+	S3Client *storage.S3Client
+}
+
+/*
+The VerifyIndexes method handles the consistency check between the objects of a table and their index entries. It expects a JSON payload with table hash and an optional repair flag.
+It reports the index entries of the table's objects that point at soft-deleted objects or hold a stale S3 key copy, walking each object's entries through gsi1.
+Entries whose object no longer exists are only found by the indexcheck command, which scans the whole indexes table.
+If repair is set, the reported entries are deleted or rewritten before responding, unless their object was written since the check.
+*/
+func (h *AdminHandler) VerifyIndexes(c *gin.Context) {
+	tenantId := c.GetString("tenantId")
+	var req admin.VerifyIndexesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.Dynamo.VerifyIndexes(c.Request.Context(), tenantId, req.TableHash, req.Repair, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify indexes: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+/*
+The SetIndexShards method enables write sharding for a hot index. It expects a JSON payload with table hash, index name and shard count.
+New index entries are spread across the shard partitions by object ID, and queries on the index merge all partitions.
+The shard count of an index is fixed once set.
+*/
+func (h *AdminHandler) SetIndexShards(c *gin.Context) {
+	tenantId := c.GetString("tenantId")
+	var req admin.SetIndexShardsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	index := objects.Index{Name: req.IndexName}
+	indexName := index.GetIndexName()
+	err := h.Dynamo.SetIndexShards(c.Request.Context(), tenantId, req.TableHash, indexName, req.Shards)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+			return
+		}
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Index is already sharded"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set index shards: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, admin.SetIndexShardsResponse{
+		TableHash: req.TableHash,
+		IndexName: indexName,
+		Shards:    req.Shards,
+	})
+}
+
+/*
+The SetHistoryRetention method configures version history for a table. It expects a JSON payload with table hash, and the number of past versions and days to keep.
+Once enabled, every update archives the version it replaces, which can then be listed, read and restored through the object endpoints.
+*/
+func (h *AdminHandler) SetHistoryRetention(c *gin.Context) {
+	tenantId := c.GetString("tenantId")
+	var req admin.SetHistoryRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.Dynamo.SetHistoryRetention(c.Request.Context(), tenantId, req.TableHash, req.MaxVersions, req.MaxAgeDays)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set history retention: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, admin.SetHistoryRetentionResponse{
+		TableHash:   req.TableHash,
+		MaxVersions: req.MaxVersions,
+		MaxAgeDays:  req.MaxAgeDays,
+	})
+}
+
+/*
+The SetSoftDeleteRetention method configures how long soft-deleted objects stay recoverable. It expects a JSON payload with the retention in days,
+and a table hash to override the tenant's retention for a single table. A null retention removes the setting, a zero one purges objects as soon as they are deleted.
+The retention applies to objects deleted afterwards, both to their DynamoDB expiry and to the outbox purge of their blobs.
+*/
+func (h *AdminHandler) SetSoftDeleteRetention(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req admin.SetSoftDeleteRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Dynamo.SetSoftDeleteRetention(ctx, tenantId, req.TableHash, req.RetentionDays); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			if req.TableHash == "" {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set soft-delete retention: " + err.Error()})
+		return
+	}
+
+	resp := admin.SetSoftDeleteRetentionResponse{TableHash: req.TableHash}
+	if req.TableHash != "" {
+		retentionDays, err := h.Dynamo.SoftDeleteRetentionDays(ctx, tenantId, req.TableHash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Retention set, but failed to read it back: " + err.Error()})
+			return
+		}
+		resp.RetentionDays = retentionDays
+	} else {
+		resp.RetentionDays = models.DefaultSoftDeleteRetentionDays
+		if req.RetentionDays != nil {
+			resp.RetentionDays = *req.RetentionDays
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+/*
+The ReconcileBlobs method handles the garbage collection of large object blobs. It expects a JSON payload with table hash and an optional mode.
+It reports the blobs of the table that are no longer referenced by an object, a retained past version or a pending upload.
+The default dry_run mode only reports them; tag hands them to the bucket lifecycle rules with the table's soft-delete retention and delete removes them immediately.
+*/
+func (h *AdminHandler) ReconcileBlobs(c *gin.Context) {
+	tenantId := c.GetString("tenantId")
+	var req admin.ReconcileBlobsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = admin.ReconcileModeDryRun
+	}
+
+	report, err := storage.ReconcileBlobs(c.Request.Context(), h.Dynamo, h.S3Client, tenantId, req.TableHash, req.Mode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile blobs: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// batchWriteConcurrency bounds the number of items of a batch put or delete written at the same time.
+const batchWriteConcurrency = 8
+
+// bindBatchRequest binds the JSON body of a batch request, rejecting bodies larger than objects.MaxBatchRequestBytes with 413.
+func bindBatchRequest(c *gin.Context, req any) bool {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, objects.MaxBatchRequestBytes)
+	if err := c.ShouldBindJSON(req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Batch request body exceeds the size limit"})
+			return false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// uniqueObjectIds returns ids without duplicates, in order of first appearance: BatchGetItem rejects duplicate keys.
+func uniqueObjectIds(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+/*
+The BatchGet method handles loading up to objects.MaxBatchGetItems objects by ID in a single request.
+Objects are read with BatchGetItem, and keys left unprocessed by DynamoDB are retried by the storage layer.
+Each requested ID gets its own result, with the status a single get would have returned: missing, deleted or pending objects do not fail the batch.
+*/
+func (h *ObjectHandler) BatchGet(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.BatchGetRequest
+	if !bindBatchRequest(c, &req) {
+		return
+	}
+
+	objectsByID, err := h.Dynamo.BatchGetObjects(ctx, tenantId, req.TableHash, uniqueObjectIds(req.ObjectIDs), req.ConsistentRead, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get objects from DynamoDB: " + err.Error()})
+		return
+	}
+
+	items := make([]objects.BatchGetItem, len(req.ObjectIDs))
+	for i, id := range req.ObjectIDs {
+		item := objects.BatchGetItem{ObjectID: id, Status: http.StatusOK}
+		obj, ok := objectsByID[id]
+		switch {
+		case !ok:
+			item.Status, item.Error = http.StatusNotFound, "Object not found"
+		case obj.Status == models.StatusDeleted:
+			item.Status, item.Error = http.StatusGone, "Object is deleted"
+		case obj.Status != models.StatusReady:
+			item.Status, item.Error = http.StatusBadRequest, "Object is not in READY status"
+		default:
+			getUrl := ""
+			if obj.S3Key != "" {
+				getUrl, err = h.S3Client.PresignGetObjectUrl(ctx, obj.S3Key, h.PresignTTL)
+				if err != nil {
+					item.Status, item.Error = http.StatusInternalServerError, "Failed to generate presigned GET URL: "+err.Error()
+					break
+				}
+			}
+			item.Object = &objects.ResultObject{
+				ObjectID:          id,
+				GetURL:            getUrl,
+				EncryptedBlob:     obj.EncryptedBlob,
+				KMSWrappedDEK:     obj.KMSWrappedDEK,
+				MasterWrappedDEK:  obj.MasterWrappedDEK,
+				DEKNonce:          obj.DEKNonce,
+				CreatedAt:         obj.CreatedAt,
+				UpdatedAt:         obj.UpdatedAt,
+				Version:           obj.Version,
+				ExpiresAt:         expiryTime(&obj),
+				ChecksumSHA256:    obj.ChecksumSHA256,
+				EncryptedMetadata: obj.EncryptedMetadata,
+			}
+		}
+		items[i] = item
+	}
+
+	c.JSON(http.StatusOK, objects.BatchGetResponse{
+		Items:           items,
+		ReadConsistency: readConsistency(req.ConsistentRead),
+	})
+}
+
+/*
+The BatchPut method handles creating or updating up to objects.MaxBatchWriteItems objects in a single request.
+Each item is written like a single put, atomically with its indexes, so a failed item leaves the others in place.
+The response reports the outcome of every item in request order, with 200 as long as the batch itself was valid.
+*/
+func (h *ObjectHandler) BatchPut(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.BatchPutRequest
+	if !bindBatchRequest(c, &req) {
+		return
+	}
+
+	results := runBatchWrites(ctx, len(req.Items), func(ctx context.Context, i int) objects.BatchWriteResult {
+		item := req.Items[i]
+		result := objects.BatchWriteResult{ObjectID: item.ObjectID}
+		if err := validatePutVersion(item.ObjectID, item.Version); err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+			return result
+		}
+		if err := validateExpiry(item.ExpiresAt, item.TTLSeconds); err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+			return result
+		}
+		if err := validateEncryptedMetadata(item.EncryptedMetadata); err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+			return result
+		}
+		resp, err := h.putObject(ctx, tenantId, &objects.PutObjectRequest{
+			ObjectID:           item.ObjectID,
+			TableHash:          req.TableHash,
+			EncryptedBlob:      item.EncryptedBlob,
+			EncryptedMetadata:  item.EncryptedMetadata,
+			KMSEncryptedDEK:    item.KMSEncryptedDEK,
+			MasterEncryptedDEK: item.MasterEncryptedDEK,
+			DEKNonce:           item.DEKNonce,
+			Indexes:            item.Indexes,
+			Sensitivity:        item.Sensitivity,
+			Version:            item.Version,
+			ExpiresAt:          item.ExpiresAt,
+			TTLSeconds:         item.TTLSeconds,
+		})
+		if err != nil {
+			result.Status, result.Error = writeErrorStatus(err, "Failed to write object in DynamoDB")
+			return result
+		}
+		result.ObjectID = resp.ObjectID
+		result.Version = resp.Version
+		result.Status = http.StatusCreated
+		return result
+	})
+
+	c.JSON(http.StatusOK, batchWriteResponse(results))
+}
+
+/*
+The BatchDelete method handles soft-deleting up to objects.MaxBatchWriteItems objects in a single request.
+Each object is deleted like a single delete, and the response reports the outcome of every ID in request order.
+*/
+func (h *ObjectHandler) BatchDelete(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.BatchDeleteRequest
+	if !bindBatchRequest(c, &req) {
+		return
+	}
+
+	results := runBatchWrites(ctx, len(req.ObjectIDs), func(ctx context.Context, i int) objects.BatchWriteResult {
+		result := objects.BatchWriteResult{ObjectID: req.ObjectIDs[i], Status: http.StatusOK}
+		if err := h.deleteObject(ctx, tenantId, req.TableHash, req.ObjectIDs[i]); err != nil {
+			result.Status, result.Error = deleteErrorStatus(err)
+		}
+		return result
+	})
+
+	c.JSON(http.StatusOK, batchWriteResponse(results))
+}
+
+// runBatchWrites runs write for each of the n items of a batch, at most batchWriteConcurrency at a time, and returns the results in item order.
+func runBatchWrites(ctx context.Context, n int, write func(ctx context.Context, i int) objects.BatchWriteResult) []objects.BatchWriteResult {
+	results := make([]objects.BatchWriteResult, n)
+	sem := make(chan struct{}, batchWriteConcurrency)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = write(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func batchWriteResponse(results []objects.BatchWriteResult) objects.BatchWriteResponse {
+	resp := objects.BatchWriteResponse{Items: results}
+	for _, result := range results {
+		if result.Status < 300 {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	return resp
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+/*
+The GetBlob method handles downloading the encrypted blob of a large object through the API server, for clients that cannot reach S3.
+It expects the object ID in the path and the table hash, optionally a past version, as query parameters, with the same checks as Get.
+The blob is streamed from S3 as it is read. A single-range Range header is honored with 206, other Range headers are ignored.
+*/
+func (h *ObjectHandler) GetBlob(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	objectId := c.Param("id")
+	var req objects.GetBlobRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	obj, err := h.Dynamo.GetObject(ctx, tenantId, req.TableHash, objectId, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get object from DynamoDB: " + err.Error()})
+		return
+	}
+	if obj == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+	if obj.Status == models.StatusDeleted {
+		c.JSON(http.StatusGone, gin.H{"error": "Object is deleted"})
+		return
+	}
+	if obj.Status != models.StatusReady {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Object is not in READY status"})
+		return
+	}
+	version, s3Key, checksum := obj.Version, obj.S3Key, obj.ChecksumSHA256
+	if req.Version != 0 && req.Version != obj.Version {
+		past, err := h.Dynamo.GetObjectVersion(ctx, tenantId, req.TableHash, objectId, req.Version, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get object version from DynamoDB: " + err.Error()})
+			return
+		}
+		if past == nil || past.Version > obj.Version {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		version, s3Key, checksum = past.Version, past.S3Key, past.ChecksumSHA256
+	}
+	if s3Key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Object is stored inline, use get instead"})
+		return
+	}
+
+	// S3 serves a single range only
+	byteRange := c.GetHeader("Range")
+	if !strings.HasPrefix(byteRange, "bytes=") || strings.Contains(byteRange, ",") {
+		byteRange = ""
+	}
+	blob, err := h.S3Client.GetBlob(ctx, s3Key, byteRange)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidRange):
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "Range is not satisfiable"})
+		case errors.Is(err, storage.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blob not found in S3"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blob from S3: " + err.Error()})
+		}
+		return
+	}
+	defer blob.Body.Close()
+
+	headers := map[string]string{
+		"Accept-Ranges": "bytes",
+		"ETag":          objects.ObjectETag(objectId, version),
+	}
+	if checksum != "" {
+		// checksum of the whole blob, also for a range
+		headers["X-Checksum-Sha256"] = checksum
+	}
+	status := http.StatusOK
+	if blob.ContentRange != "" {
+		status = http.StatusPartialContent
+		headers["Content-Range"] = blob.ContentRange
+	}
+	c.DataFromReader(status, blob.ContentLength, "application/octet-stream", blob.Body, headers)
+}
+
+/*
+The PutBlob method handles uploading the encrypted blob of a reserved large object upload through the API server, for clients that cannot reach S3.
+It expects the object ID in the path, the table hash and reserved version as query parameters, and the blob as the body; for a multipart upload,
+one part per request with its part number. The Content-Length must be exactly the declared size of the blob or part, and the body is streamed to S3
+as it arrives. The upload is then confirmed with ConfirmPutLarge as if it had gone to the presigned URL.
+*/
+func (h *ObjectHandler) PutBlob(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	objectId := c.Param("id")
+	var req objects.PutBlobRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Request.ContentLength < 0 {
+		c.JSON(http.StatusLengthRequired, gin.H{"error": "Content-Length is required"})
+		return
+	}
+
+	reservation, err := h.Dynamo.GetUploadReservation(ctx, tenantId, req.TableHash, objectId, req.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get upload reservation from DynamoDB: " + err.Error()})
+		return
+	}
+	if reservation == nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload is not reserved or its reservation expired, request a new upload URL"})
+		return
+	}
+
+	size := reservation.BlobSize
+	if reservation.UploadID == "" && req.PartNumber != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "part_number is only valid for multipart uploads"})
+		return
+	}
+	if reservation.UploadID != "" {
+		if req.PartNumber == 0 || req.PartNumber > reservation.PartCount() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("part_number must be between 1 and %d for this multipart upload", reservation.PartCount())})
+			return
+		}
+		size = reservation.PartLength(req.PartNumber)
+	}
+	if c.Request.ContentLength != size {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Content-Length must be %d, the declared size", size)})
+		return
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, size)
+
+	resp := objects.PutBlobResponse{
+		ObjectID:   objectId,
+		Version:    req.Version,
+		PartNumber: req.PartNumber,
+		Size:       size,
+	}
+	if reservation.UploadID == "" {
+		if err := h.S3Client.PutBlob(ctx, reservation.S3Key, body, size, reservation.ChecksumSHA256); err != nil {
+			if errors.Is(err, storage.ErrChecksumMismatch) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Blob does not match the declared SHA-256 checksum"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload blob to S3: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	etag, err := h.S3Client.UploadPart(ctx, reservation.S3Key, reservation.UploadID, req.PartNumber, body, size)
+	if err != nil {
+		if errors.Is(err, storage.ErrUploadNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Multipart upload is no longer in progress, confirm or abort the upload"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload part to S3: " + err.Error()})
+		return
+	}
+	resp.ETag = etag
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/apps/api/internal/pagination"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+)
+
+const (
+	exportPageSize = 100
+	// records buffered between the segment readers and the response writer, readers block once it is full
+	exportBufferSize = 256
+)
+
+/*
+The Export method streams every ready object of a table as NDJSON. It expects a JSON payload with table hash, an optional number of segments, and optional checkpoints to resume from.
+The object ID space is split into segments that are read concurrently, each segment emits its objects in order followed by a checkpoint after every page.
+Checkpoints stay valid for ExportCheckpointTTL, independently of the pagination token lifetime.
+Objects include their wrapped DEKs and, for S3-backed blobs, a presigned GET URL. The stream ends with an "end" record, or an "error" record if a segment fails.
+Segment readers block while the client is not reading, so a slow consumer does not make the server buffer the table.
+*/
+func (h *ObjectHandler) Export(c *gin.Context) {
+	tenantId := c.GetString("tenantId")
+	var req objects.ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	segments := req.Segments
+	if segments == 0 {
+		segments = objects.DefaultExportSegments
+	}
+
+	// checkpoints are validated before the stream starts, so that a bad one is reported with a status code
+	cursors := make(map[int][]byte, segments)
+	for segment := range segments {
+		cursors[segment] = nil
+	}
+	for segment, checkpoint := range req.Checkpoints {
+		if segment < 0 || segment >= segments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Checkpoint for unknown segment %d", segment)})
+			return
+		}
+		cursor, err := h.Pagination.Open(exportScope(tenantId, req.TableHash, segment, segments), checkpoint)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid or expired checkpoint for segment %d", segment)})
+			return
+		}
+		if len(cursor) == 0 {
+			// the segment was already exported entirely
+			delete(cursors, segment)
+			continue
+		}
+		cursors[segment] = cursor
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	records := make(chan objects.ExportRecord, exportBufferSize)
+	var wg sync.WaitGroup
+	for segment, cursor := range cursors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.exportSegment(ctx, tenantId, req.TableHash, segment, segments, cursor, records)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(records)
+	}()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	failed := false
+	for record := range records {
+		if failed {
+			// drain until every segment reader has stopped
+			continue
+		}
+		if err := enc.Encode(record); err != nil {
+			// the client went away
+			failed = true
+			cancel()
+			continue
+		}
+		if record.Type != objects.ExportRecordObject {
+			c.Writer.Flush()
+		}
+		if record.Type == objects.ExportRecordError {
+			failed = true
+			cancel()
+		}
+	}
+	if !failed {
+		enc.Encode(objects.ExportRecord{Type: objects.ExportRecordEnd})
+		c.Writer.Flush()
+	}
+}
+
+// exportSegment reads a segment page by page and sends its objects and checkpoints, until the segment is exhausted, a read fails, or ctx is cancelled.
+func (h *ObjectHandler) exportSegment(ctx context.Context, tenantId string, tableHash string, segment int, totalSegments int, cursor []byte, records chan<- objects.ExportRecord) {
+	send := func(record objects.ExportRecord) bool {
+		record.Segment = segment
+		select {
+		case records <- record:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	fail := func(err error) {
+		if ctx.Err() == nil {
+			send(objects.ExportRecord{Type: objects.ExportRecordError, Error: err.Error()})
+		}
+	}
+
+	scope := exportScope(tenantId, tableHash, segment, totalSegments)
+	for {
+		page, err := h.Dynamo.ScanTableSegment(ctx, tenantId, tableHash, segment, totalSegments, exportPageSize, cursor)
+		if err != nil {
+			fail(err)
+			return
+		}
+		for _, obj := range page.Objects {
+			getUrl := ""
+			if obj.S3Key != "" {
+				getUrl, err = h.S3Client.PresignGetObjectUrl(ctx, obj.S3Key, h.PresignTTL)
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+			if !send(objects.ExportRecord{
+				Type: objects.ExportRecordObject,
+				Object: &objects.ResultObject{
+					ObjectID:          obj.GetObjectID(),
+					GetURL:            getUrl,
+					EncryptedBlob:     obj.EncryptedBlob,
+					KMSWrappedDEK:     obj.KMSWrappedDEK,
+					MasterWrappedDEK:  obj.MasterWrappedDEK,
+					DEKNonce:          obj.DEKNonce,
+					CreatedAt:         obj.CreatedAt,
+					UpdatedAt:         obj.UpdatedAt,
+					Version:           obj.Version,
+					ExpiresAt:         expiryTime(&obj),
+					ChecksumSHA256:    obj.ChecksumSHA256,
+					EncryptedMetadata: obj.EncryptedMetadata,
+				},
+			}) {
+				return
+			}
+		}
+
+		cursor = page.NextCursor
+		checkpointCursor := cursor
+		if checkpointCursor == nil {
+			// an empty cursor marks the segment as done
+			checkpointCursor = []byte{}
+		}
+		checkpoint, err := h.Pagination.SealWithTTL(scope, checkpointCursor, h.ExportCheckpointTTL)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if !send(objects.ExportRecord{Type: objects.ExportRecordCheckpoint, Checkpoint: *checkpoint, Done: cursor == nil}) || cursor == nil {
+			return
+		}
+	}
+}
+
+// exportScope binds a checkpoint to its segment, a checkpoint is only valid for an export with the same number of segments.
+func exportScope(tenantId string, tableHash string, segment int, totalSegments int) pagination.Scope {
+	return pagination.Scope{
+		TenantID:  tenantId,
+		TableHash: tableHash,
+		Operation: fmt.Sprintf("export:%d/%d", segment, totalSegments),
+	}
+}
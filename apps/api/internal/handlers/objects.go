@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/apps/api/internal/metrics"
+	"github.com/qodesrl/gardbase/apps/api/internal/pagination"
 	"github.com/qodesrl/gardbase/apps/api/internal/services"
 	"github.com/qodesrl/gardbase/apps/api/internal/storage"
 	"github.com/qodesrl/gardbase/pkg/api/objects"
@@ -23,6 +30,11 @@ type ObjectHandler struct {
 	KMS        *services.KMS
 	PresignTTL time.Duration
 	BaseURL    string
+	Pagination *pagination.Codec
+	// time given to upload and confirm a large object, unconfirmed blobs are swept once it has passed
+	UploadReservationTTL time.Duration
+	// lifetime of export checkpoints, longer than pagination tokens since exports of large tables are resumed hours later
+	ExportCheckpointTTL time.Duration
 }
 
 /*
@@ -114,6 +126,26 @@ func (h *ObjectHandler) RequestPutLarge(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Blob size must be greater than 100KB for large object upload"})
 		return
 	}
+	if req.ChecksumSHA256 != "" {
+		if checksum, _ := base64.StdEncoding.DecodeString(req.ChecksumSHA256); len(checksum) != sha256.Size {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "checksum_sha256 must be a base64 SHA-256 digest"})
+			return
+		}
+	}
+	var partSize int64
+	if req.Multipart {
+		partSize = req.PartSize
+		if partSize == 0 {
+			partSize = objects.DefaultUploadPartSize
+		}
+		if (req.BlobSize+partSize-1)/partSize > objects.MaxUploadParts {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Blob size needs more than %d parts, use a larger part size", objects.MaxUploadParts)})
+			return
+		}
+	} else if req.BlobSize > objects.MaxSinglePartUploadSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Blob size exceeds the 5GB single-part upload limit, use a multipart upload"})
+		return
+	}
 
 	var objectId string
 	var expectedVersion int32
@@ -125,7 +157,7 @@ func (h *ObjectHandler) RequestPutLarge(c *gin.Context) {
 	} else {
 		objectId = req.ObjectID
 		// Update - check if object exists
-		obj, err := h.Dynamo.GetObject(ctx, tenantId, req.TableHash, req.ObjectID)
+		obj, err := h.Dynamo.GetObject(ctx, tenantId, req.TableHash, req.ObjectID, false)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get object from DynamoDB: " + err.Error()})
 			return
@@ -146,19 +178,57 @@ func (h *ObjectHandler) RequestPutLarge(c *gin.Context) {
 	}
 
 	s3Key := generateS3Key(tenantId, req.TableHash, objectId, expectedVersion)
+	reservation := models.NewUploadReservation(tenantId, req.TableHash, objectId, expectedVersion, s3Key, req.BlobSize, time.Now().Add(h.UploadReservationTTL))
+	reservation.ChecksumSHA256 = req.ChecksumSHA256
+	resp := objects.RequestPutLargeObjectResponse{
+		ObjectID:        objectId,
+		ExpectedVersion: expectedVersion,
+		ExpiresIn:       int64(h.PresignTTL.Seconds()),
+		ConfirmBy:       time.Unix(reservation.ExpiresAt, 0).UTC(),
+	}
+
+	if !req.Multipart {
+		uploadUrl, uploadHeaders, err := h.S3Client.PresignPutObjectUrl(ctx, s3Key, req.BlobSize, req.ChecksumSHA256, h.PresignTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned PUT URL: " + err.Error()})
+			return
+		}
+		if err := h.Dynamo.ReserveUpload(ctx, reservation); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve upload in DynamoDB: " + err.Error()})
+			return
+		}
+		metrics.UploadsReserved.Add(1)
+		resp.UploadURL = uploadUrl
+		resp.UploadHeaders = uploadHeaders
+		c.JSON(http.StatusOK, resp)
+		return
+	}
 
-	uploadUrl, err := h.S3Client.PresignPutObjectUrl(ctx, s3Key, h.PresignTTL)
+	// the multipart upload is reserved before its part URLs are handed out, so that the sweeper aborts it if it is never confirmed
+	uploadId, err := h.S3Client.CreateMultipartUpload(ctx, s3Key)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned PUT URL: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create multipart upload in S3: " + err.Error()})
 		return
 	}
+	reservation.UploadID = uploadId
+	reservation.PartSize = partSize
+	if err := h.Dynamo.ReserveUpload(ctx, reservation); err != nil {
+		_, _ = h.S3Client.AbortMultipartUpload(context.WithoutCancel(ctx), s3Key, uploadId)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve upload in DynamoDB: " + err.Error()})
+		return
+	}
+	metrics.UploadsReserved.Add(1)
 
-	resp := objects.RequestPutLargeObjectResponse{
-		ObjectID:        objectId,
-		UploadURL:       uploadUrl,
-		ExpectedVersion: expectedVersion,
-		ExpiresIn:       int64(h.PresignTTL.Seconds()),
+	partNumbers, nextPart := partsPage(missingParts(reservation.PartCount(), nil), 1)
+	parts, err := h.S3Client.PresignUploadPartUrls(ctx, reservation, partNumbers, h.PresignTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned part URLs: " + err.Error()})
+		return
 	}
+	resp.UploadID = uploadId
+	resp.PartSize = partSize
+	resp.Parts = parts
+	resp.NextPart = nextPart
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -172,33 +242,89 @@ func (h *ObjectHandler) ConfirmPutLarge(c *gin.Context) {
 	// Get tenant ID from context
 	tenantId := c.GetString("tenantId")
 
-	// Verify uploaded object exists in S3
+	if err := validateExpiry(req.ExpiresAt, req.TTLSeconds); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateEncryptedMetadata(req.EncryptedMetadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the reservation is claimed first, so that the sweeper cannot remove the blob while the object is written
+	reservation, err := h.Dynamo.ClaimUpload(ctx, tenantId, req.TableHash, req.ObjectID, req.Version)
+	if err != nil {
+		if errors.Is(err, storage.ErrUploadNotReserved) {
+			c.JSON(http.StatusGone, gin.H{"error": "Upload is not reserved or its reservation expired, request a new upload URL"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim upload reservation in DynamoDB: " + err.Error()})
+		return
+	}
+	giveBack := true
+	defer func() {
+		if giveBack {
+			// give the reservation back, so that the client can retry or the sweeper removes the blob once it expires
+			_ = h.Dynamo.ReserveUpload(context.WithoutCancel(ctx), reservation)
+		}
+	}()
+
 	s3Key := generateS3Key(tenantId, req.TableHash, req.ObjectID, req.Version)
-	exists, err := h.S3Client.CheckObjectExists(ctx, s3Key)
+	if reservation.UploadID != "" {
+		// an upload not found was completed by an earlier confirmation that then failed, the existence check below covers it
+		err := h.S3Client.CompleteMultipartUpload(ctx, s3Key, reservation.UploadID, req.Parts)
+		if err != nil && !errors.Is(err, storage.ErrUploadNotFound) {
+			if errors.Is(err, storage.ErrInvalidUploadParts) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded parts are missing, too small or do not match their ETags"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete multipart upload in S3: " + err.Error()})
+			return
+		}
+	}
+
+	// Verify the uploaded blob against what was declared, the signed URLs already made S3 enforce it
+	blob, err := h.S3Client.HeadBlob(ctx, s3Key)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check object in S3: " + err.Error()})
 		return
 	}
-	if !exists {
+	if blob == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded object not found in S3"})
 		return
 	}
+	if blob.Size != reservation.BlobSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Uploaded object is %d bytes, %d were declared", blob.Size, reservation.BlobSize)})
+		return
+	}
+	// S3 only keeps the SHA-256 of single-part uploads, multipart blobs are verified by the client on download
+	if reservation.ChecksumSHA256 != "" && reservation.UploadID == "" && blob.ChecksumSHA256 != reservation.ChecksumSHA256 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded object does not match the declared SHA-256 checksum"})
+		return
+	}
 
 	now := time.Now().UTC()
+	expiresAt := objectExpiry(req.ExpiresAt, req.TTLSeconds, now)
 
 	if req.Version == 1 {
 		// Create
 		obj := models.NewObject(tenantId, req.TableHash, req.ObjectID, req.KMSEncryptedDEK, req.MasterEncryptedDEK, req.DEKNonce)
 		obj.S3Key = s3Key
+		obj.ChecksumSHA256 = reservation.ChecksumSHA256
+		obj.EncryptedMetadata = req.EncryptedMetadata
 		obj.Version = 1
 		obj.Status = models.StatusReady
 		obj.CreatedAt = now
 		obj.UpdatedAt = now
+		obj.SetExpiry(expiresAt)
 
 		if err := h.Dynamo.CreateObjectWithIndexes(ctx, req.TableHash, obj, req.Indexes); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to put object in DynamoDB: " + err.Error()})
+			giveBack = !blobMayBeReferenced(err)
+			respondWriteError(c, err, "Failed to put object in DynamoDB")
 			return
 		}
+		giveBack = false
+		metrics.UploadsConfirmed.Add(1)
 
 		resp := objects.ConfirmPutLargeObjectResponse{
 			ObjectID:  req.ObjectID,
@@ -206,6 +332,7 @@ func (h *ObjectHandler) ConfirmPutLarge(c *gin.Context) {
 			UpdatedAt: obj.UpdatedAt,
 			TableHash: req.TableHash,
 			Version:   obj.Version,
+			ExpiresAt: expiryTime(obj),
 		}
 		c.JSON(http.StatusOK, resp)
 		return
@@ -214,19 +341,25 @@ func (h *ObjectHandler) ConfirmPutLarge(c *gin.Context) {
 	// Update
 	obj, err := h.Dynamo.UpdateObjectWithIndexes(ctx, tenantId, req.TableHash, req.ObjectID, req.Version-1, func(obj *models.Object) {
 		obj.S3Key = s3Key
+		obj.ChecksumSHA256 = reservation.ChecksumSHA256
+		obj.EncryptedMetadata = req.EncryptedMetadata // replaced even if omitted, the previous one is encrypted under the previous DEK
 		obj.KMSWrappedDEK = req.KMSEncryptedDEK
 		obj.MasterWrappedDEK = req.MasterEncryptedDEK
 		obj.DEKNonce = req.DEKNonce
 		obj.UpdatedAt = now
 		obj.Version = req.Version
+		obj.SetExpiry(expiresAt)
 		if req.Sensitivity != "" {
 			obj.Sensitivity = req.Sensitivity
 		}
 	}, req.Indexes)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update object in DynamoDB: " + err.Error()})
+		giveBack = !blobMayBeReferenced(err)
+		respondWriteError(c, err, "Failed to update object in DynamoDB")
 		return
 	}
+	giveBack = false
+	metrics.UploadsConfirmed.Add(1)
 
 	resp := objects.ConfirmPutLargeObjectResponse{
 		ObjectID:  req.ObjectID,
@@ -234,10 +367,154 @@ func (h *ObjectHandler) ConfirmPutLarge(c *gin.Context) {
 		UpdatedAt: obj.UpdatedAt,
 		TableHash: req.TableHash,
 		Version:   req.Version,
+		ExpiresAt: expiryTime(obj),
 	}
 	c.JSON(http.StatusOK, resp)
 }
 
+/*
+blobMayBeReferenced tells whether the object may reference the uploaded blob after a confirmation failed with err, so that its reservation must not be
+given back: a version mismatch may come from an earlier confirmation of the same upload that succeeded without its response reaching the client,
+and a pending object may be a staged write of this confirmation that the outbox will settle.
+*/
+func blobMayBeReferenced(err error) bool {
+	return errors.Is(err, storage.ErrVersionMismatch) || errors.Is(err, storage.ErrPending)
+}
+
+/*
+The ResumePutLarge method hands out new upload URLs for a reserved large object upload, e.g. after the previous ones expired or the upload was interrupted.
+It expects a JSON payload with table hash, object ID, the reserved version and, for a multipart upload, the part to resume from.
+The reservation is extended by the upload reservation TTL, so that an upload still in progress is not swept while its URLs are valid.
+For a multipart upload it returns the parts S3 already received and presigned URLs for up to MaxPresignedParts missing ones,
+along with the next part to resume from; for a single-part upload a new PUT URL.
+*/
+func (h *ObjectHandler) ResumePutLarge(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req objects.ResumePutLargeObjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantId := c.GetString("tenantId")
+
+	reservation, err := h.Dynamo.GetUploadReservation(ctx, tenantId, req.TableHash, req.ObjectID, req.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get upload reservation from DynamoDB: " + err.Error()})
+		return
+	}
+	if reservation == nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload is not reserved or its reservation expired, request a new upload URL"})
+		return
+	}
+	if expiresAt := time.Now().Add(h.UploadReservationTTL); expiresAt.Unix() > reservation.ExpiresAt {
+		if err := h.Dynamo.ExtendUploadReservation(ctx, reservation, expiresAt); err != nil {
+			if errors.Is(err, storage.ErrUploadNotReserved) {
+				c.JSON(http.StatusGone, gin.H{"error": "Upload is not reserved or its reservation expired, request a new upload URL"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extend upload reservation in DynamoDB: " + err.Error()})
+			return
+		}
+	}
+
+	resp := objects.ResumePutLargeObjectResponse{
+		ObjectID:  req.ObjectID,
+		ExpiresIn: int64(h.PresignTTL.Seconds()),
+		ConfirmBy: time.Unix(reservation.ExpiresAt, 0).UTC(),
+	}
+	if reservation.UploadID == "" {
+		uploadUrl, uploadHeaders, err := h.S3Client.PresignPutObjectUrl(ctx, reservation.S3Key, reservation.BlobSize, reservation.ChecksumSHA256, h.PresignTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned PUT URL: " + err.Error()})
+			return
+		}
+		resp.UploadURL = uploadUrl
+		resp.UploadHeaders = uploadHeaders
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	uploaded, err := h.S3Client.ListUploadedParts(ctx, reservation.S3Key, reservation.UploadID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUploadNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Multipart upload is no longer in progress, confirm or abort the upload"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list uploaded parts in S3: " + err.Error()})
+		return
+	}
+	partNumbers, nextPart := partsPage(missingParts(reservation.PartCount(), uploaded), max(req.FromPart, 1))
+	parts, err := h.S3Client.PresignUploadPartUrls(ctx, reservation, partNumbers, h.PresignTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned part URLs: " + err.Error()})
+		return
+	}
+	resp.UploadID = reservation.UploadID
+	resp.PartSize = reservation.PartSize
+	resp.UploadedParts = uploaded
+	resp.Parts = parts
+	resp.NextPart = nextPart
+	c.JSON(http.StatusOK, resp)
+}
+
+/*
+The AbortPutLarge method cancels a reserved large object upload. It expects a JSON payload with table hash, object ID and the reserved version.
+The multipart upload, if any, is aborted and the uploaded blob deleted, then the reservation is released so that the version can be requested again.
+*/
+func (h *ObjectHandler) AbortPutLarge(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req objects.AbortPutLargeObjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantId := c.GetString("tenantId")
+
+	reservation, err := h.Dynamo.ClaimUpload(ctx, tenantId, req.TableHash, req.ObjectID, req.Version)
+	if err != nil {
+		if errors.Is(err, storage.ErrUploadNotReserved) {
+			c.JSON(http.StatusGone, gin.H{"error": "Upload is not reserved or its reservation expired"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim upload reservation in DynamoDB: " + err.Error()})
+		return
+	}
+	if _, _, err := h.S3Client.DiscardUpload(ctx, reservation.S3Key, reservation.UploadID); err != nil {
+		// give the reservation back, so that the sweeper discards the upload once it expires
+		_ = h.Dynamo.ReserveUpload(context.WithoutCancel(ctx), reservation)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to discard upload in S3: " + err.Error()})
+		return
+	}
+	metrics.UploadsAborted.Add(1)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted successfully"})
+}
+
+// partsPage returns up to MaxPresignedParts of the given ascending part numbers from fromPart on, and the part number following them, 0 if none is left.
+func partsPage(partNumbers []int32, fromPart int32) ([]int32, int32) {
+	start, _ := slices.BinarySearch(partNumbers, fromPart)
+	end := min(start+objects.MaxPresignedParts, len(partNumbers))
+	if end == len(partNumbers) {
+		return partNumbers[start:end], 0
+	}
+	return partNumbers[start:end], partNumbers[end]
+}
+
+// missingParts returns the numbers of the parts of a multipart upload of partCount parts that are not among the uploaded ones.
+func missingParts(partCount int32, uploaded []objects.UploadedPart) []int32 {
+	received := make(map[int32]bool, len(uploaded))
+	for _, part := range uploaded {
+		received[part.PartNumber] = true
+	}
+	missing := make([]int32, 0, max(int(partCount)-len(uploaded), 0))
+	for partNumber := int32(1); partNumber <= partCount; partNumber++ {
+		if !received[partNumber] {
+			missing = append(missing, partNumber)
+		}
+	}
+	return missing
+}
+
 func (h *ObjectHandler) Put(c *gin.Context) {
 	ctx := c.Request.Context()
 	var req objects.PutObjectRequest
@@ -247,83 +524,183 @@ func (h *ObjectHandler) Put(c *gin.Context) {
 	}
 
 	// Validate: version and ID must be consistent
-	if req.ObjectID != "" && req.Version == 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Version must be provided for updates"})
+	if err := validatePutVersion(req.ObjectID, req.Version); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if req.ObjectID == "" && req.Version != 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Version should not be provided for new objects"})
+	if err := validateExpiry(req.ExpiresAt, req.TTLSeconds); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateEncryptedMetadata(req.EncryptedMetadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Get tenant ID from context
 	tenantId := c.GetString("tenantId")
+
+	resp, err := h.putObject(ctx, tenantId, &req)
+	if err != nil {
+		if req.ObjectID == "" {
+			respondWriteError(c, err, "Failed to create object in DynamoDB")
+		} else {
+			respondWriteError(c, err, "Failed to update object in DynamoDB")
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// validateExpiry checks the expiry fields of a write, at most one of them can be set and an absolute expiry must be in the future.
+func validateExpiry(expiresAt *time.Time, ttlSeconds int64) error {
+	if expiresAt != nil && ttlSeconds != 0 {
+		return errors.New("Only one of expires_at and ttl_seconds can be set")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// validateEncryptedMetadata checks that the encrypted metadata of a write fits in MaxEncryptedMetadataSize.
+func validateEncryptedMetadata(metadata []byte) error {
+	if len(metadata) > objects.MaxEncryptedMetadataSize {
+		return fmt.Errorf("encrypted_metadata must be at most %d bytes", objects.MaxEncryptedMetadataSize)
+	}
+	return nil
+}
+
+// objectExpiry returns the Unix time at which an object written at now expires, zero if it does not.
+func objectExpiry(expiresAt *time.Time, ttlSeconds int64, now time.Time) int64 {
+	switch {
+	case expiresAt != nil:
+		return expiresAt.Unix()
+	case ttlSeconds > 0:
+		return now.Unix() + ttlSeconds
+	}
+	return 0
+}
+
+// expiryTime returns the expiry of an object as reported in responses, nil if it does not expire.
+func expiryTime(obj *models.Object) *time.Time {
+	if obj.ExpiresAt == 0 {
+		return nil
+	}
+	expiresAt := time.Unix(obj.ExpiresAt, 0).UTC()
+	return &expiresAt
+}
+
+func validatePutVersion(objectId string, version int32) error {
+	if objectId != "" && version == 1 {
+		return errors.New("Version must be provided for updates")
+	}
+	if objectId == "" && version != 1 {
+		return errors.New("Version should not be provided for new objects")
+	}
+	return nil
+}
+
+// putObject creates the object if req has no object ID, or updates it from version req.Version-1 otherwise.
+func (h *ObjectHandler) putObject(ctx context.Context, tenantId string, req *objects.PutObjectRequest) (*objects.PutObjectResponse, error) {
 	now := time.Now().UTC()
+	expiresAt := objectExpiry(req.ExpiresAt, req.TTLSeconds, now)
 
 	if req.ObjectID == "" {
 		// Create
 		objectId := uuid.NewString()
 		obj := models.NewObject(tenantId, req.TableHash, objectId, req.KMSEncryptedDEK, req.MasterEncryptedDEK, req.DEKNonce)
 		obj.EncryptedBlob = req.EncryptedBlob
+		obj.EncryptedMetadata = req.EncryptedMetadata
 		obj.Version = 1
 		obj.CreatedAt = now
 		obj.UpdatedAt = now
 		obj.Status = models.StatusReady
+		obj.SetExpiry(expiresAt)
 		if req.Sensitivity != "" {
 			obj.Sensitivity = req.Sensitivity
 		} else {
 			obj.Sensitivity = models.SensitivityLow
 		}
 		if err := h.Dynamo.CreateObjectWithIndexes(ctx, req.TableHash, obj, req.Indexes); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create object in DynamoDB: " + err.Error()})
-			return
+			return nil, err
 		}
 
-		resp := objects.PutObjectResponse{
+		return &objects.PutObjectResponse{
 			ObjectID:  objectId,
 			CreatedAt: obj.CreatedAt,
 			UpdatedAt: obj.UpdatedAt,
 			TableHash: req.TableHash,
 			Version:   obj.Version,
-		}
-
-		c.JSON(http.StatusCreated, resp)
-		return
+			ExpiresAt: expiryTime(obj),
+		}, nil
 	}
 
 	// Update
 	obj, err := h.Dynamo.UpdateObjectWithIndexes(ctx, tenantId, req.TableHash, req.ObjectID, req.Version-1, func(obj *models.Object) {
 		obj.EncryptedBlob = req.EncryptedBlob
 		obj.S3Key = "" // clear s3key if switching from large object to inline
+		obj.ChecksumSHA256 = ""
+		obj.EncryptedMetadata = req.EncryptedMetadata // replaced even if omitted, the previous one is encrypted under the previous DEK
 		obj.KMSWrappedDEK = req.KMSEncryptedDEK
 		obj.MasterWrappedDEK = req.MasterEncryptedDEK
 		obj.DEKNonce = req.DEKNonce
 		obj.UpdatedAt = now
 		obj.Version = req.Version
+		obj.SetExpiry(expiresAt)
 		if req.Sensitivity != "" {
 			obj.Sensitivity = req.Sensitivity
 		}
 	}, req.Indexes)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update object in DynamoDB: " + err.Error()})
-		return
+		return nil, err
 	}
 
-	resp := objects.PutObjectResponse{
+	return &objects.PutObjectResponse{
 		ObjectID:  req.ObjectID,
 		CreatedAt: obj.CreatedAt,
 		UpdatedAt: obj.UpdatedAt,
 		TableHash: req.TableHash,
 		Version:   obj.Version,
+		ExpiresAt: expiryTime(obj),
+	}, nil
+}
+
+/*
+The PutIndexes method handles index-only writes for an existing object version. It expects a JSON payload with table hash, object ID, current version and the index tokens to upsert.
+It does not touch the object blob or bump its version, which lets clients backfill indexes for fields that became searchable after the objects were written.
+Finally, it responds with the number of index entries that were created or replaced.
+*/
+func (h *ObjectHandler) PutIndexes(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.PutIndexesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusCreated, resp)
+	written, err := h.Dynamo.UpsertIndexes(ctx, tenantId, req.TableHash, req.ObjectID, req.Version, req.Indexes)
+	if err != nil {
+		respondWriteError(c, err, "Failed to write indexes in DynamoDB")
+		return
+	}
+
+	c.JSON(http.StatusOK, objects.PutIndexesResponse{
+		ObjectID: req.ObjectID,
+		Version:  req.Version,
+		Written:  written,
+	})
 }
 
 /*
 The Get method handles the retrieval of an object through its ID.
 It expects a JSON payload with tenant ID, table hash, and object ID. It retrieves the object from DynamoDB using the GetObject method.
 If the object is found and is in READY status, it generates a presigned GET URL if the object is stored in S3.
+The response carries an ETag derived from the object version: if the If-None-Match header matches it, a 304 without body is returned,
+and if expected_version is set and the object is at another version, a 409 is returned.
+If version is set, that version is returned instead of the current one, as long as the table's history retention still holds it.
 Finally, it responds with the object ID, presigned GET URL (if applicable), encrypted blob (if inline), KMS-wrapped DEK, master-wrapped DEK, DEK nonce, and timestamps.
 */
 func (h *ObjectHandler) Get(c *gin.Context) {
@@ -335,7 +712,7 @@ func (h *ObjectHandler) Get(c *gin.Context) {
 		return
 	}
 
-	obj, err := h.Dynamo.GetObject(ctx, tenantId, req.TableHash, req.ObjectID)
+	obj, err := h.Dynamo.GetObject(ctx, tenantId, req.TableHash, req.ObjectID, req.ConsistentRead)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get object from DynamoDB: " + err.Error()})
 		return
@@ -352,6 +729,41 @@ func (h *ObjectHandler) Get(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Object is not in READY status"})
 		return
 	}
+	if req.ExpectedVersion != 0 && obj.Version != req.ExpectedVersion {
+		c.JSON(http.StatusConflict, gin.H{"error": "Version mismatch, the object has been updated", "current_version": obj.Version})
+		return
+	}
+	if req.Version != 0 && req.Version != obj.Version {
+		past, err := h.Dynamo.GetObjectVersion(ctx, tenantId, req.TableHash, req.ObjectID, req.Version, req.ConsistentRead)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get object version from DynamoDB: " + err.Error()})
+			return
+		}
+		if past == nil || past.Version > obj.Version {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		obj = &models.Object{
+			EncryptedBlob:     past.EncryptedBlob,
+			S3Key:             past.S3Key,
+			ChecksumSHA256:    past.ChecksumSHA256,
+			EncryptedMetadata: past.EncryptedMetadata,
+			KMSWrappedDEK:     past.KMSWrappedDEK,
+			MasterWrappedDEK:  past.MasterWrappedDEK,
+			DEKNonce:          past.DEKNonce,
+			CreatedAt:         past.CreatedAt,
+			UpdatedAt:         past.UpdatedAt,
+			Version:           past.Version,
+		}
+	}
+
+	etag := objects.ObjectETag(req.ObjectID, obj.Version)
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		// the client's cached copy is current, skip the blob and the presigned URL
+		c.Status(http.StatusNotModified)
+		return
+	}
 
 	getUrl := ""
 	encryptedBlob := obj.EncryptedBlob
@@ -365,24 +777,45 @@ func (h *ObjectHandler) Get(c *gin.Context) {
 	}
 
 	resp := objects.GetObjectResponse{
-		ObjectID:         req.ObjectID,
-		GetURL:           getUrl,
-		EncryptedBlob:    encryptedBlob,
-		KMSWrappedDEK:    obj.KMSWrappedDEK,
-		MasterWrappedDEK: obj.MasterWrappedDEK,
-		DEKNonce:         obj.DEKNonce,
-		CreatedAt:        obj.CreatedAt,
-		UpdatedAt:        obj.UpdatedAt,
-		Version:          obj.Version,
+		ResultObject: objects.ResultObject{
+			ObjectID:          req.ObjectID,
+			GetURL:            getUrl,
+			EncryptedBlob:     encryptedBlob,
+			KMSWrappedDEK:     obj.KMSWrappedDEK,
+			MasterWrappedDEK:  obj.MasterWrappedDEK,
+			DEKNonce:          obj.DEKNonce,
+			CreatedAt:         obj.CreatedAt,
+			UpdatedAt:         obj.UpdatedAt,
+			Version:           obj.Version,
+			ExpiresAt:         expiryTime(obj),
+			ChecksumSHA256:    obj.ChecksumSHA256,
+			EncryptedMetadata: obj.EncryptedMetadata,
+		},
+		ReadConsistency: readConsistency(req.ConsistentRead),
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
 
+// etagMatches reports whether an If-None-Match header value matches etag, weak validators are compared as strong ones.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 The Scan method handles scanning objects in a table with pagination support. It expects a JSON payload with tenant ID, table hash, optional limit, and next token.
 It retrieves the objects from DynamoDB using the ScanTable method, which returns a list of objects and a next token for pagination.
-For each object, it generates a presigned GET URL if the object is stored in S3.
+For each object, it generates a presigned GET URL if the object is stored in S3, unless only the metadata of the objects is projected:
+blobs are then neither read nor presigned, and objects carry their encrypted metadata and DEKs only.
 Finally, it responds with a list of objects and the next token for pagination.
 */
 func (h *ObjectHandler) Scan(c *gin.Context) {
@@ -395,33 +828,58 @@ func (h *ObjectHandler) Scan(c *gin.Context) {
 		return
 	}
 
-	nextToken := ""
+	scope := pagination.Scope{TenantID: tenantId, TableHash: req.TableHash, Operation: "scan"}
+	var cursor []byte
 	if req.NextToken != nil {
-		nextToken = *req.NextToken
+		var err error
+		cursor, err = h.Pagination.Open(scope, *req.NextToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+			return
+		}
 	}
 
-	result, err := h.Dynamo.ScanTable(ctx, tenantId, req.TableHash, req.Limit, nextToken)
+	result, err := h.Dynamo.ScanTable(ctx, tenantId, req.TableHash, req.Limit, cursor, req.ConsistentRead, req.Projection == objects.ProjectionMetadata)
 	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan objects from DynamoDB: " + err.Error()})
 		return
 	}
+	nextToken, err := h.Pagination.Seal(scope, result.NextCursor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate next_token: " + err.Error()})
+		return
+	}
 
 	var resp objects.ScanResponse
 	for _, obj := range result.Objects {
 		resp.Objects = append(resp.Objects, objects.ResultObject{
-			ObjectID:         obj.SK[len("OBJ#"):],
-			GetURL:           obj.S3Key,
-			EncryptedBlob:    obj.EncryptedBlob,
-			KMSWrappedDEK:    obj.KMSWrappedDEK,
-			MasterWrappedDEK: obj.MasterWrappedDEK,
-			DEKNonce:         obj.DEKNonce,
-			CreatedAt:        obj.CreatedAt,
-			UpdatedAt:        obj.UpdatedAt,
-			Version:          obj.Version,
+			ObjectID:          obj.SK[len("OBJ#"):],
+			GetURL:            obj.S3Key,
+			EncryptedBlob:     obj.EncryptedBlob,
+			KMSWrappedDEK:     obj.KMSWrappedDEK,
+			MasterWrappedDEK:  obj.MasterWrappedDEK,
+			DEKNonce:          obj.DEKNonce,
+			CreatedAt:         obj.CreatedAt,
+			UpdatedAt:         obj.UpdatedAt,
+			Version:           obj.Version,
+			ExpiresAt:         expiryTime(&obj),
+			ChecksumSHA256:    obj.ChecksumSHA256,
+			EncryptedMetadata: obj.EncryptedMetadata,
 		})
 	}
-	resp.NextToken = result.NextToken
+	// the results carry their S3 key until it is presigned, metadata-only results have none
+	if err := h.presignResultObjects(ctx, resp.Objects); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned GET URL: " + err.Error()})
+		return
+	}
+	resp.NextToken = nextToken
 	resp.Count = result.Count
+	resp.ScannedCount = result.ScannedCount
+	resp.ReadConsistency = readConsistency(req.ConsistentRead)
 
 	c.JSON(http.StatusOK, resp)
 }
@@ -436,33 +894,58 @@ func (h *ObjectHandler) Query(c *gin.Context) {
 		return
 	}
 
-	nextToken := ""
+	scope := queryScope(tenantId, &req)
+	var cursor []byte
 	if req.NextToken != nil {
-		nextToken = *req.NextToken
+		var err error
+		cursor, err = h.Pagination.Open(scope, *req.NextToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+			return
+		}
 	}
 
-	result, err := h.Dynamo.QueryIndexes(ctx, tenantId, req.TableHash, req.Index, req.BetweenRange, req.RangeOp, req.Limit, nextToken, req.ScanForward)
+	result, err := h.Dynamo.QueryIndexes(ctx, tenantId, req.TableHash, req.Index, req.BetweenRange, req.RangeOp, req.Limit, cursor, req.ScanForward, req.ConsistentRead, req.Projection == objects.ProjectionMetadata)
 	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan objects from DynamoDB: " + err.Error()})
 		return
 	}
+	nextToken, err := h.Pagination.Seal(scope, result.NextCursor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate next_token: " + err.Error()})
+		return
+	}
 
 	var resp objects.QueryResponse
 	for _, obj := range result.Objects {
 		resp.Objects = append(resp.Objects, objects.ResultObject{
-			ObjectID:         obj.SK[len("OBJ#"):],
-			GetURL:           obj.S3Key,
-			EncryptedBlob:    obj.EncryptedBlob,
-			KMSWrappedDEK:    obj.KMSWrappedDEK,
-			MasterWrappedDEK: obj.MasterWrappedDEK,
-			DEKNonce:         obj.DEKNonce,
-			CreatedAt:        obj.CreatedAt,
-			UpdatedAt:        obj.UpdatedAt,
-			Version:          obj.Version,
+			ObjectID:          obj.SK[len("OBJ#"):],
+			GetURL:            obj.S3Key,
+			EncryptedBlob:     obj.EncryptedBlob,
+			KMSWrappedDEK:     obj.KMSWrappedDEK,
+			MasterWrappedDEK:  obj.MasterWrappedDEK,
+			DEKNonce:          obj.DEKNonce,
+			CreatedAt:         obj.CreatedAt,
+			UpdatedAt:         obj.UpdatedAt,
+			Version:           obj.Version,
+			ExpiresAt:         expiryTime(&obj),
+			ChecksumSHA256:    obj.ChecksumSHA256,
+			EncryptedMetadata: obj.EncryptedMetadata,
 		})
 	}
-	resp.NextToken = result.NextToken
+	// the results carry their S3 key until it is presigned, metadata-only results have none
+	if err := h.presignResultObjects(ctx, resp.Objects); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned GET URL: " + err.Error()})
+		return
+	}
+	resp.NextToken = nextToken
 	resp.Count = result.Count
+	resp.ScannedCount = result.ScannedCount
+	resp.ReadConsistency = readConsistency(req.ConsistentRead)
 
 	c.JSON(http.StatusOK, resp)
 }
@@ -474,28 +957,69 @@ func (h *ObjectHandler) Delete(c *gin.Context) {
 	var req objects.DeleteObjectRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	s3Key, err := h.Dynamo.SoftDeleteObjectAndIndexes(ctx, tenantId, req.TableHash, req.ObjectID)
-	if err != nil {
-		if errors.Is(err, storage.ErrNotFoundOrDeleted) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Object not found or already deleted"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete object in DynamoDB: " + err.Error()})
+	if err := h.deleteObject(ctx, tenantId, req.TableHash, req.ObjectID); err != nil {
+		status, message := deleteErrorStatus(err)
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{"message": "Object deleted successfully"})
+}
+
+// deleteObject soft-deletes an object for the soft-delete retention of its table, then schedules its removal.
+func (h *ObjectHandler) deleteObject(ctx context.Context, tenantId string, tableHash string, objectId string) error {
+	retentionDays, err := h.Dynamo.SoftDeleteRetentionDays(ctx, tenantId, tableHash)
+	if err != nil {
+		return fmt.Errorf("failed to read soft-delete retention: %w", err)
+	}
+	s3Key, err := h.Dynamo.SoftDeleteObjectAndIndexes(ctx, tenantId, tableHash, objectId, retentionDays)
+	if err != nil {
+		return err
+	}
+	key := ""
 	if s3Key != nil {
-		if err := h.S3Client.TagForDeletion(ctx, *s3Key); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to tag S3 object for deletion: " + err.Error()})
+		key = *s3Key
+	}
+	h.expireDeleted(ctx, tenantId, tableHash, objectId, key, retentionDays)
+	return nil
+}
+
+/*
+expireDeleted follows up on a soft delete, whose purge was already scheduled in the outbox for when the object leaves the trash.
+With zero retention the object is purged right away, like TrashPurge does; otherwise its blob, if any, is tagged for reporting.
+Both are best effort: the delete has been committed, and a failed purge is retried by the outbox task.
+*/
+func (h *ObjectHandler) expireDeleted(ctx context.Context, tenantId string, tableHash string, objectId string, s3Key string, retentionDays int) {
+	if retentionDays == 0 {
+		if err := h.Dynamo.PurgeObject(ctx, tenantId, tableHash, objectId); err != nil {
 			return
 		}
+		_, _ = h.S3Client.PurgePrefix(ctx, storage.ObjectS3Prefix(tenantId, tableHash, objectId))
+		return
 	}
+	if s3Key != "" {
+		_ = h.S3Client.TagDeleted(ctx, s3Key, time.Now().AddDate(0, 0, retentionDays))
+	}
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Object deleted successfully"})
+func deleteErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFoundOrDeleted):
+		return http.StatusNotFound, "Object not found or already deleted"
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return http.StatusConflict, "Object was modified concurrently, retry the delete"
+	default:
+		return http.StatusInternalServerError, "Failed to delete object: " + err.Error()
+	}
 }
 
+/*
+The Recover method handles recovering a soft-deleted object. It expects a JSON payload with table hash and object ID.
+The object is flipped back to ready together with the index entries it had when it was deleted, so it is immediately reachable through queries again.
+*/
 func (h *ObjectHandler) Recover(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantId := c.GetString("tenantId")
@@ -505,27 +1029,96 @@ func (h *ObjectHandler) Recover(c *gin.Context) {
 		return
 	}
 
-	s3Key, err := h.Dynamo.UndeleteObject(ctx, tenantId, req.TableHash, req.ObjectID)
-	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recover object in DynamoDB: " + err.Error()})
+	if err := h.recoverObject(ctx, tenantId, req.TableHash, req.ObjectID); err != nil {
+		status, message := recoverErrorStatus(err)
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{"message": "Object recovered successfully"})
+}
+
+// recoverObject restores a soft-deleted object and removes the deletion tag from its blob, if any. The tag is only reported, removing it is best effort.
+func (h *ObjectHandler) recoverObject(ctx context.Context, tenantId string, tableHash string, objectId string) error {
+	s3Key, err := h.Dynamo.UndeleteObject(ctx, tenantId, tableHash, objectId)
+	if err != nil {
+		return err
+	}
 	if s3Key != nil {
-		if err := h.S3Client.UntagForDeletion(ctx, *s3Key); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to untag S3 object for deletion: " + err.Error()})
-			return
-		}
+		_ = h.S3Client.UntagForDeletion(ctx, *s3Key)
 	}
+	return nil
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Object recovered successfully"})
+func recoverErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFoundOrDeleted):
+		return http.StatusNotFound, "Object not found or not deleted"
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return http.StatusConflict, "Object was modified concurrently, retry the recover"
+	case errors.Is(err, storage.ErrIndexConflict):
+		return http.StatusConflict, "An index entry of the object is held by another object, it cannot be recovered"
+	default:
+		return http.StatusInternalServerError, "Failed to recover object: " + err.Error()
+	}
+}
+
+// Helper function to map storage write errors to HTTP responses
+func respondWriteError(c *gin.Context, err error, message string) {
+	status, errMessage := writeErrorStatus(err, message)
+	c.JSON(status, gin.H{"error": errMessage})
+}
+
+// writeErrorStatus maps an error returned by an object write to an HTTP status and message, message prefixes unexpected errors.
+func writeErrorStatus(err error, message string) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound, "Object not found"
+	case errors.Is(err, storage.ErrNotFoundOrDeleted):
+		return http.StatusGone, "Object is deleted"
+	case errors.Is(err, storage.ErrAlreadyExists):
+		return http.StatusConflict, "Object already exists"
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return http.StatusConflict, "Version mismatch, the object has been updated"
+	case errors.Is(err, storage.ErrPending):
+		return http.StatusConflict, "Object has a pending write, retry later"
+	default:
+		return http.StatusInternalServerError, message + ": " + err.Error()
+	}
 }
 
 // Helper function to generate S3 key
 func generateS3Key(tenantId string, tableHash string, objectId string, version int32) string {
-	return "tenant-" + tenantId + "/" + tableHash + "/" + objectId + "/v" + fmt.Sprintf("%d", version)
+	return storage.ObjectS3Prefix(tenantId, tableHash, objectId) + "v" + fmt.Sprintf("%d", version)
+}
+
+// queryScope binds the pagination token of a query to its index, key condition and direction.
+func queryScope(tenantId string, req *objects.QueryRequest) pagination.Scope {
+	direction := "desc"
+	if req.ScanForward {
+		direction = "asc"
+	}
+	var condition []byte
+	condition = append(condition, req.Index.TokenHash...)
+	condition = append(condition, req.Index.TokenRange...)
+	condition = append(condition, req.BetweenRange[0]...)
+	condition = append(condition, req.BetweenRange[1]...)
+	return pagination.Scope{
+		TenantID:  tenantId,
+		TableHash: req.TableHash,
+		Index:     req.Index.GetIndexName(),
+		Operation: fmt.Sprintf("query:%d:%s", req.RangeOp, direction),
+		Condition: condition,
+	}
+}
+
+/*
+readConsistency reports the guarantee of a read served from the objects table or an index partition, which both support strongly consistent reads.
+Lookups through the gsi1 secondary index are always eventually consistent and must not use it.
+*/
+func readConsistency(consistentRead bool) string {
+	if consistentRead {
+		return objects.ReadConsistencyStrong
+	}
+	return objects.ReadConsistencyEventual
 }
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// presignConcurrency bounds the number of download URLs presigned at the same time for a single request.
+const presignConcurrency = 16
+
+// presignGetUrls presigns a download URL for each S3 key, at most presignConcurrency at a time. URLs are returned in key order, empty for an empty key.
+func (h *ObjectHandler) presignGetUrls(ctx context.Context, keys []string) ([]string, error) {
+	urls := make([]string, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, presignConcurrency)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			urls[i], errs[i] = h.S3Client.PresignGetObjectUrl(ctx, key, h.PresignTTL)
+		}(i, key)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
+
+// presignResultObjects replaces the S3 keys that results carry in GetURL with presigned download URLs.
+func (h *ObjectHandler) presignResultObjects(ctx context.Context, results []objects.ResultObject) error {
+	keys := make([]string, len(results))
+	for i, result := range results {
+		keys[i] = result.GetURL
+	}
+	urls, err := h.presignGetUrls(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		results[i].GetURL = urls[i]
+	}
+	return nil
+}
+
+/*
+The Presign method handles refreshing the download URLs of up to objects.MaxBatchGetItems large objects in a single request.
+It expects a JSON payload with table hash and object IDs, and returns a URL for the current version of each object without its keys or blob,
+so that clients can renew the URLs of scan or query results once they expire. Each requested ID gets its own result, like a batch get.
+*/
+func (h *ObjectHandler) Presign(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.PresignRequest
+	if !bindBatchRequest(c, &req) {
+		return
+	}
+
+	objectsByID, err := h.Dynamo.BatchGetObjects(ctx, tenantId, req.TableHash, uniqueObjectIds(req.ObjectIDs), false, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get objects from DynamoDB: " + err.Error()})
+		return
+	}
+
+	items := make([]objects.PresignItem, len(req.ObjectIDs))
+	keys := make([]string, len(req.ObjectIDs))
+	for i, id := range req.ObjectIDs {
+		item := objects.PresignItem{ObjectID: id, Status: http.StatusOK}
+		obj, ok := objectsByID[id]
+		switch {
+		case !ok:
+			item.Status, item.Error = http.StatusNotFound, "Object not found"
+		case obj.Status == models.StatusDeleted:
+			item.Status, item.Error = http.StatusGone, "Object is deleted"
+		case obj.Status != models.StatusReady:
+			item.Status, item.Error = http.StatusBadRequest, "Object is not in READY status"
+		case obj.S3Key == "":
+			item.Status, item.Error = http.StatusBadRequest, "Object is stored inline, it has no download URL"
+		default:
+			item.Version = obj.Version
+			item.ChecksumSHA256 = obj.ChecksumSHA256
+			keys[i] = obj.S3Key
+		}
+		items[i] = item
+	}
+
+	urls, err := h.presignGetUrls(ctx, keys)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate presigned GET URL: " + err.Error()})
+		return
+	}
+	for i := range items {
+		items[i].GetURL = urls[i]
+	}
+
+	c.JSON(http.StatusOK, objects.PresignResponse{
+		Items:     items,
+		ExpiresIn: int64(h.PresignTTL.Seconds()),
+	})
+}
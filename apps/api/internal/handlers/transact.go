@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+/*
+The Transact method handles applying puts, deletes and condition checks on several objects of the tenant atomically, possibly across tables.
+It expects a JSON payload with up to objects.MaxTransactOperations operations; every operation on an existing object carries the version it expects.
+The operations and their index changes are compiled into a single DynamoDB transaction, so either all of them are applied or none is.
+If the transaction is rejected, it responds with the index of the operation that caused it: 409 for version conflicts, 404 or 410 for missing objects.
+*/
+func (h *ObjectHandler) Transact(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.TransactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	ops := make([]storage.TransactOp, len(req.Operations))
+	targets := make(map[string]int, len(req.Operations))
+	retentions := make(map[string]int)
+	for i, reqOp := range req.Operations {
+		if err := validateTransactOperation(&reqOp); err != nil {
+			c.JSON(http.StatusBadRequest, objects.TransactErrorResponse{Error: err.Error(), FailedOperation: i})
+			return
+		}
+		if reqOp.ObjectID != "" {
+			// DynamoDB rejects transactions that touch the same item twice
+			target := reqOp.TableHash + "/" + reqOp.ObjectID
+			if first, ok := targets[target]; ok {
+				c.JSON(http.StatusBadRequest, objects.TransactErrorResponse{
+					Error:           fmt.Sprintf("Object is already targeted by operation %d", first),
+					FailedOperation: i,
+				})
+				return
+			}
+			targets[target] = i
+		}
+
+		op := storage.TransactOp{
+			Type:            reqOp.Type,
+			TableHash:       reqOp.TableHash,
+			ObjectID:        reqOp.ObjectID,
+			ExpectedVersion: reqOp.ExpectedVersion,
+			Indexes:         reqOp.Indexes,
+		}
+		if reqOp.Type == objects.TransactDelete {
+			retentionDays, ok := retentions[reqOp.TableHash]
+			if !ok {
+				var err error
+				retentionDays, err = h.Dynamo.SoftDeleteRetentionDays(ctx, tenantId, reqOp.TableHash)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read soft-delete retention: " + err.Error()})
+					return
+				}
+				retentions[reqOp.TableHash] = retentionDays
+			}
+			op.RetentionDays = retentionDays
+		}
+		if reqOp.Type == objects.TransactPut && reqOp.ObjectID == "" {
+			obj := models.NewObject(tenantId, reqOp.TableHash, uuid.NewString(), reqOp.KMSEncryptedDEK, reqOp.MasterEncryptedDEK, reqOp.DEKNonce)
+			obj.EncryptedBlob = reqOp.EncryptedBlob
+			obj.EncryptedMetadata = reqOp.EncryptedMetadata
+			obj.Version = 1
+			obj.CreatedAt = now
+			obj.UpdatedAt = now
+			obj.Status = models.StatusReady
+			obj.SetExpiry(objectExpiry(reqOp.ExpiresAt, reqOp.TTLSeconds, now))
+			obj.Sensitivity = models.SensitivityLow
+			if reqOp.Sensitivity != "" {
+				obj.Sensitivity = reqOp.Sensitivity
+			}
+			op.Object = obj
+		} else if reqOp.Type == objects.TransactPut {
+			op.Apply = func(obj *models.Object) {
+				obj.EncryptedBlob = reqOp.EncryptedBlob
+				obj.S3Key = "" // clear s3key if switching from large object to inline
+				obj.ChecksumSHA256 = ""
+				obj.EncryptedMetadata = reqOp.EncryptedMetadata // replaced even if omitted, the previous one is encrypted under the previous DEK
+				obj.KMSWrappedDEK = reqOp.KMSEncryptedDEK
+				obj.MasterWrappedDEK = reqOp.MasterEncryptedDEK
+				obj.DEKNonce = reqOp.DEKNonce
+				obj.UpdatedAt = now
+				obj.Version = reqOp.ExpectedVersion + 1
+				obj.SetExpiry(objectExpiry(reqOp.ExpiresAt, reqOp.TTLSeconds, now))
+				if reqOp.Sensitivity != "" {
+					obj.Sensitivity = reqOp.Sensitivity
+				}
+			}
+		}
+		ops[i] = op
+	}
+
+	written, err := h.Dynamo.TransactObjects(ctx, tenantId, ops)
+	if err != nil {
+		var txErr *storage.TransactError
+		if errors.As(err, &txErr) {
+			status, message := writeErrorStatus(txErr.Err, "Transaction failed")
+			c.JSON(status, objects.TransactErrorResponse{Error: message, FailedOperation: txErr.Operation})
+			return
+		}
+		if errors.Is(err, storage.ErrTransactionTooLarge) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction has too many index changes, the operations and their indexes must fit in 100 items"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply transaction in DynamoDB: " + err.Error()})
+		return
+	}
+
+	resp := objects.TransactResponse{Results: make([]objects.TransactResult, len(ops))}
+	for i, op := range ops {
+		result := objects.TransactResult{
+			Type:      op.Type,
+			TableHash: op.TableHash,
+			ObjectID:  op.ObjectID,
+			Version:   op.ExpectedVersion,
+		}
+		if obj := written[i]; obj != nil {
+			result.ObjectID = obj.GetObjectID()
+			result.Version = obj.Version
+			result.UpdatedAt = obj.UpdatedAt
+			if op.Type == objects.TransactDelete {
+				h.expireDeleted(ctx, tenantId, op.TableHash, op.ObjectID, obj.S3Key, op.RetentionDays)
+			}
+		}
+		resp.Results[i] = result
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func validateTransactOperation(op *objects.TransactOperation) error {
+	if op.Type == objects.TransactPut {
+		if len(op.EncryptedBlob) == 0 || len(op.KMSEncryptedDEK) == 0 || len(op.MasterEncryptedDEK) == 0 || len(op.DEKNonce) == 0 {
+			return errors.New("Puts require encrypted_blob, encrypted_dek, master_encrypted_dek and dek_nonce")
+		}
+		if op.ObjectID == "" && op.ExpectedVersion != 0 {
+			return errors.New("Expected version should not be provided for new objects")
+		}
+		if op.ObjectID != "" && op.ExpectedVersion < 1 {
+			return errors.New("Expected version must be provided for updates")
+		}
+		if err := validateEncryptedMetadata(op.EncryptedMetadata); err != nil {
+			return err
+		}
+		return validateExpiry(op.ExpiresAt, op.TTLSeconds)
+	}
+	if op.ObjectID == "" {
+		return errors.New("Object ID must be provided for deletes and condition checks")
+	}
+	if op.ExpectedVersion < 1 {
+		return errors.New("Expected version must be provided for deletes and condition checks")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/apps/api/internal/pagination"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+)
+
+/*
+The TrashList method handles listing the soft-deleted objects of a table. It expects a JSON payload with table hash, optional limit and next token.
+Each object is reported with the time it was deleted and the time it expires, after which it can no longer be recovered.
+*/
+func (h *ObjectHandler) TrashList(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.TrashListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = objects.DefaultTrashListLimit
+	}
+
+	scope := pagination.Scope{TenantID: tenantId, TableHash: req.TableHash, Operation: "trash"}
+	var cursor []byte
+	if req.NextToken != nil {
+		var err error
+		cursor, err = h.Pagination.Open(scope, *req.NextToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+			return
+		}
+	}
+
+	result, err := h.Dynamo.ListDeletedObjects(ctx, tenantId, req.TableHash, limit, cursor)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deleted objects: " + err.Error()})
+		return
+	}
+
+	now := time.Now()
+	resp := objects.TrashListResponse{Objects: make([]objects.TrashedObject, 0, len(result.Objects))}
+	for _, obj := range result.Objects {
+		trashed := objects.TrashedObject{
+			ObjectID:  obj.GetObjectID(),
+			Version:   obj.Version,
+			Large:     obj.S3Key != "",
+			DeletedAt: obj.UpdatedAt,
+		}
+		if obj.TTL != 0 {
+			if obj.TTL <= now.Unix() {
+				// expired, DynamoDB has not removed it yet
+				continue
+			}
+			expiresAt := time.Unix(obj.TTL, 0).UTC()
+			trashed.ExpiresAt = &expiresAt
+		}
+		resp.Objects = append(resp.Objects, trashed)
+	}
+	resp.NextToken, err = h.Pagination.Seal(scope, result.NextCursor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create next_token: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+/*
+The TrashRecover method handles recovering several soft-deleted objects at once. It expects a JSON payload with table hash and object IDs.
+Each object is recovered like a single recover, and the response reports the outcome of every ID in request order.
+*/
+func (h *ObjectHandler) TrashRecover(c *gin.Context) {
+	tenantId := c.GetString("tenantId")
+	var req objects.TrashRecoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := runBatchWrites(c.Request.Context(), len(req.ObjectIDs), func(ctx context.Context, i int) objects.BatchWriteResult {
+		result := objects.BatchWriteResult{ObjectID: req.ObjectIDs[i], Status: http.StatusOK}
+		if err := h.recoverObject(ctx, tenantId, req.TableHash, req.ObjectIDs[i]); err != nil {
+			result.Status, result.Error = recoverErrorStatus(err)
+		}
+		return result
+	})
+
+	c.JSON(http.StatusOK, batchWriteResponse(results))
+}
+
+/*
+The TrashPurge method handles permanently erasing soft-deleted objects, for erasure requests that cannot wait for the trash to expire.
+It expects a JSON payload with table hash and object IDs. For each object, the DynamoDB record, its index entries and archived versions are deleted,
+then every stored version of its blobs is deleted from S3. Objects that are not deleted are rejected with 409.
+A failed purge can be repeated: objects already removed from DynamoDB still have their leftover data erased.
+*/
+func (h *ObjectHandler) TrashPurge(c *gin.Context) {
+	tenantId := c.GetString("tenantId")
+	var req objects.TrashPurgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := runBatchWrites(c.Request.Context(), len(req.ObjectIDs), func(ctx context.Context, i int) objects.BatchWriteResult {
+		objectId := req.ObjectIDs[i]
+		result := objects.BatchWriteResult{ObjectID: objectId, Status: http.StatusOK}
+		if err := h.Dynamo.PurgeObject(ctx, tenantId, req.TableHash, objectId); err != nil {
+			if errors.Is(err, storage.ErrNotDeleted) {
+				result.Status, result.Error = http.StatusConflict, "Object must be deleted before it is purged"
+				return result
+			}
+			result.Status, result.Error = http.StatusInternalServerError, "Failed to purge object from DynamoDB: "+err.Error()
+			return result
+		}
+		if _, err := h.S3Client.PurgePrefix(ctx, storage.ObjectS3Prefix(tenantId, req.TableHash, objectId)); err != nil {
+			result.Status, result.Error = http.StatusInternalServerError, fmt.Sprintf("Failed to purge object blobs from S3, retry the purge: %v", err)
+		}
+		return result
+	})
+
+	c.JSON(http.StatusOK, batchWriteResponse(results))
+}
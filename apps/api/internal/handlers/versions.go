@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/apps/api/internal/pagination"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+/*
+The ListVersions method handles listing the versions of an object. It expects a JSON payload with table hash, object ID, optional limit and next token.
+The first page starts with the current version, followed by the past versions retained by the table's history settings, newest first.
+Blobs are not included, use Get with a version to load one.
+*/
+func (h *ObjectHandler) ListVersions(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.ListVersionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = objects.DefaultListVersionsLimit
+	}
+
+	scope := versionsScope(tenantId, req.TableHash, req.ObjectID)
+	var cursor []byte
+	if req.NextToken != nil {
+		var err error
+		cursor, err = h.Pagination.Open(scope, *req.NextToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+			return
+		}
+	}
+
+	obj, err := h.Dynamo.GetObject(ctx, tenantId, req.TableHash, req.ObjectID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get object from DynamoDB: " + err.Error()})
+		return
+	}
+	if obj == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+	if obj.Status == models.StatusDeleted {
+		c.JSON(http.StatusGone, gin.H{"error": "Object is deleted"})
+		return
+	}
+
+	resp := objects.ListVersionsResponse{Versions: make([]objects.ObjectVersionInfo, 0, limit)}
+	if cursor == nil {
+		resp.Versions = append(resp.Versions, objects.ObjectVersionInfo{
+			Version:   obj.Version,
+			Current:   true,
+			Large:     obj.S3Key != "",
+			CreatedAt: obj.CreatedAt,
+			UpdatedAt: obj.UpdatedAt,
+		})
+		limit--
+	}
+
+	if limit > 0 {
+		past, nextCursor, err := h.Dynamo.ListObjectVersions(ctx, tenantId, req.TableHash, req.ObjectID, limit, cursor)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired next_token"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list object versions: " + err.Error()})
+			return
+		}
+		for _, version := range past {
+			if version.Version >= obj.Version {
+				// archived by a staged update that did not complete, it is still the current version
+				continue
+			}
+			info := objects.ObjectVersionInfo{
+				Version:    version.Version,
+				Large:      version.S3Key != "",
+				CreatedAt:  version.CreatedAt,
+				UpdatedAt:  version.UpdatedAt,
+				ReplacedAt: &version.ReplacedAt,
+			}
+			if version.TTL != 0 {
+				expiresAt := time.Unix(version.TTL, 0).UTC()
+				info.ExpiresAt = &expiresAt
+			}
+			resp.Versions = append(resp.Versions, info)
+		}
+		resp.NextToken, err = h.Pagination.Seal(scope, nextCursor)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create next_token: " + err.Error()})
+			return
+		}
+	} else {
+		// the page only holds the current version, the past versions start on the next one
+		resp.NextToken, err = h.Pagination.Seal(scope, []byte{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create next_token: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+/*
+The RestoreVersion method handles restoring a past version of an object. It expects a JSON payload with table hash, object ID, the version to restore, and the current version.
+It writes a new version with the blob, wrapped DEKs and index entries of the past version, so the restore itself shows up in the history.
+*/
+func (h *ObjectHandler) RestoreVersion(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantId := c.GetString("tenantId")
+	var req objects.RestoreVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Version >= req.ExpectedVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only a version older than the current one can be restored"})
+		return
+	}
+
+	obj, err := h.Dynamo.RestoreObjectVersion(ctx, tenantId, req.TableHash, req.ObjectID, req.Version, req.ExpectedVersion)
+	if err != nil {
+		if errors.Is(err, storage.ErrVersionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		respondWriteError(c, err, "Failed to restore object version")
+		return
+	}
+
+	c.JSON(http.StatusOK, objects.RestoreVersionResponse{
+		ObjectID:     req.ObjectID,
+		TableHash:    req.TableHash,
+		Version:      obj.Version,
+		RestoredFrom: req.Version,
+		UpdatedAt:    obj.UpdatedAt,
+	})
+}
+
+func versionsScope(tenantId string, tableHash string, objectId string) pagination.Scope {
+	return pagination.Scope{
+		TenantID:  tenantId,
+		TableHash: tableHash,
+		Operation: "versions",
+		Condition: []byte(objectId),
+	}
+}
@@ -0,0 +1,33 @@
+// Package metrics exposes the API server counters through expvar, served as JSON on /metrics by the internal listener at METRICS_ADDR.
+package metrics
+
+import "expvar"
+
+var (
+	// Outbox tasks completed successfully
+	OutboxTasksProcessed = expvar.NewInt("outbox_tasks_processed")
+	// Outbox task attempts that failed and were rescheduled
+	OutboxTaskFailures = expvar.NewInt("outbox_task_failures")
+	// Outbox tasks found due during the last poll
+	OutboxTasksDue = expvar.NewInt("outbox_tasks_due")
+	// Seconds since the oldest due outbox task seen during the last poll became due, 0 if none
+	OutboxOldestTaskAgeSeconds = expvar.NewInt("outbox_oldest_task_age_seconds")
+	// Staged object writes interrupted before committing and settled by the outbox worker
+	StagedWritesSettled = expvar.NewInt("staged_writes_settled")
+	// Objects removed by their expiry task
+	ObjectsExpired = expvar.NewInt("objects_expired")
+	// Soft-deleted objects purged by the outbox once they left the trash
+	ObjectsPurged = expvar.NewInt("objects_purged")
+	// Large object uploads reserved by a presigned PUT URL
+	UploadsReserved = expvar.NewInt("uploads_reserved")
+	// Large object uploads confirmed before their reservation expired
+	UploadsConfirmed = expvar.NewInt("uploads_confirmed")
+	// Unconfirmed uploads whose blob was uploaded and then swept
+	UploadsAbandoned = expvar.NewInt("uploads_abandoned")
+	// Bytes of the blobs swept with abandoned uploads
+	UploadsAbandonedBytes = expvar.NewInt("uploads_abandoned_bytes")
+	// Unconfirmed uploads whose blob was never uploaded
+	UploadsExpired = expvar.NewInt("uploads_expired")
+	// Uploads cancelled by the client before being confirmed
+	UploadsAborted = expvar.NewInt("uploads_aborted")
+)
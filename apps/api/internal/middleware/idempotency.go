@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/pkg/models"
+	"go.uber.org/zap"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	// request bodies are buffered to be hashed, larger requests are rejected
+	maxIdempotentRequestBytes = 16 << 20
+	// responses are stored in a DynamoDB item (400 KB), larger responses are not stored and the key is released
+	maxIdempotentResponseBytes = 300 << 10
+	// an in-progress key can be taken over by a retry once the request holding it has had this long to complete
+	idempotencyLockTimeout = time.Minute
+)
+
+// idempotencyWriter captures the response body while writing it through to the client.
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+/*
+IdempotencyMiddleware makes write requests that carry an Idempotency-Key header safe to retry.
+The first request with a key is processed and its response is stored per tenant and key for ttl; retries with the same method, path and body
+get the stored response back with an Idempotent-Replayed header. Only 2xx and 4xx responses are stored: after a server error the key is released,
+so that a retry with the same key is processed again. Reusing a key for a different request is rejected with 422,
+and a retry that arrives while the first request is still being processed is rejected with 409.
+Requests without the header are processed as usual. Must run after TenantMiddleware.
+*/
+func IdempotencyMiddleware(dynamoClient *storage.DynamoClient, ttl time.Duration, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key header is too long"})
+			return
+		}
+		tenantId := c.GetString("tenantId")
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentRequestBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		h.Write([]byte(c.Request.Method + " " + c.FullPath() + "\n"))
+		h.Write(body)
+		requestHash := h.Sum(nil)
+
+		ctx := c.Request.Context()
+		existing, lockToken, err := dynamoClient.BeginIdempotentRequest(ctx, tenantId, key, requestHash, ttl, idempotencyLockTimeout)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check idempotency key: " + err.Error()})
+			return
+		}
+		if existing != nil {
+			if !bytes.Equal(existing.RequestHash, requestHash) {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+				return
+			}
+			if existing.State != models.IdempotencyCompleted {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+				return
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// the request context may be cancelled once the response is written, the outcome must be recorded regardless
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		status := writer.Status()
+		storable := (status >= 200 && status < 300) || (status >= 400 && status < 500)
+		if !storable || writer.body.Len() > maxIdempotentResponseBytes {
+			err := dynamoClient.ReleaseIdempotencyKey(storeCtx, tenantId, key, lockToken)
+			if errors.Is(err, storage.ErrIdempotencyKeyLost) {
+				logger.Warn("Idempotency key was taken over by a retry, leaving it to the retry", zap.String("tenant_id", tenantId), zap.Int("status", status))
+			} else if err != nil {
+				logger.Error("Failed to release idempotency key", zap.String("tenant_id", tenantId), zap.Error(err))
+			}
+			return
+		}
+		err = dynamoClient.CompleteIdempotentRequest(storeCtx, tenantId, key, lockToken, status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		if errors.Is(err, storage.ErrIdempotencyKeyLost) {
+			// the retry holding the key stores its own response
+			logger.Warn("Idempotency key was taken over by a retry, dropping the response", zap.String("tenant_id", tenantId), zap.Int("status", status))
+		} else if err != nil {
+			logger.Error("Failed to store idempotent response", zap.String("tenant_id", tenantId), zap.Error(err))
+		}
+	}
+}
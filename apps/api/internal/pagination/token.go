@@ -0,0 +1,162 @@
+// Package pagination seals the DynamoDB cursors of Scan and Query into opaque tokens handed out to clients.
+//
+// A token is bound to the tenant, table, index and operator of the listing it was issued for, authenticated with an HMAC-SHA256 of a server secret,
+// optionally encrypted with AES-GCM so that it does not leak the key layout, and expires after a configurable time.
+package pagination
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid pagination token")
+	ErrExpiredToken = fmt.Errorf("%w: expired", ErrInvalidToken)
+)
+
+const (
+	tokenVersion = 1
+
+	flagEncrypted = 1 << 0
+
+	headerLength = 2 // version, flags
+	macLength    = sha256.Size
+)
+
+// Scope identifies the listing a token was issued for. A token is only accepted for the exact same scope.
+type Scope struct {
+	TenantID  string
+	TableHash string
+	Index     string // index name, empty for scans
+	Operation string // "scan", or the query operator and direction
+	Condition []byte // index token and range bounds of a query, a cursor is meaningless for any other key condition
+}
+
+// Codec seals cursors into tokens and opens them back.
+type Codec struct {
+	macKey []byte
+	aead   cipher.AEAD // nil if tokens are only authenticated
+	ttl    time.Duration
+}
+
+/*
+NewCodec derives the authentication key, and the encryption key if encrypt is true, from the given secret.
+Tokens are valid for ttl after being issued. All API server instances must share the same secret, otherwise tokens issued by one are rejected by the others.
+*/
+func NewCodec(secret []byte, encrypt bool, ttl time.Duration) (*Codec, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("pagination token secret must be at least 32 bytes, got %d", len(secret))
+	}
+	macKey, err := hkdf.Key(sha256.New, secret, nil, "gardbase pagination token mac", 32)
+	if err != nil {
+		return nil, err
+	}
+	c := &Codec{macKey: macKey, ttl: ttl}
+	if encrypt {
+		encKey, err := hkdf.Key(sha256.New, secret, nil, "gardbase pagination token encryption", 32)
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		c.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+/*
+Seal returns the token for the given cursor, or nil if the cursor is nil (no more pages).
+Token layout, base64url encoded: version | flags | body | HMAC-SHA256(scope, version | flags | body),
+where body is expires_at (8 bytes, big endian unix seconds) | cursor, encrypted as nonce | AES-GCM ciphertext if encryption is enabled.
+*/
+func (c *Codec) Seal(scope Scope, cursor []byte) (*string, error) {
+	return c.SealWithTTL(scope, cursor, c.ttl)
+}
+
+// SealWithTTL is like Seal, for tokens that must stay valid for ttl instead of the codec's lifetime, e.g. export checkpoints.
+func (c *Codec) SealWithTTL(scope Scope, cursor []byte, ttl time.Duration) (*string, error) {
+	if cursor == nil {
+		return nil, nil
+	}
+	body := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(ttl).Unix()))
+	body = append(body, cursor...)
+
+	var flags byte
+	if c.aead != nil {
+		flags |= flagEncrypted
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		body = c.aead.Seal(nonce, nonce, body, nil)
+	}
+
+	raw := make([]byte, 0, headerLength+len(body)+macLength)
+	raw = append(raw, tokenVersion, flags)
+	raw = append(raw, body...)
+	raw = append(raw, c.mac(scope, raw)...)
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return &token, nil
+}
+
+// Open validates a token against the scope of the current request and returns its cursor. Errors wrap ErrInvalidToken.
+func (c *Codec) Open(scope Scope, token string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < headerLength+macLength {
+		return nil, ErrInvalidToken
+	}
+	signed, mac := raw[:len(raw)-macLength], raw[len(raw)-macLength:]
+	if !hmac.Equal(mac, c.mac(scope, signed)) {
+		return nil, ErrInvalidToken
+	}
+	if signed[0] != tokenVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidToken, signed[0])
+	}
+
+	body := signed[headerLength:]
+	if signed[1]&flagEncrypted != 0 {
+		if c.aead == nil || len(body) < c.aead.NonceSize() {
+			return nil, ErrInvalidToken
+		}
+		nonce, ciphertext := body[:c.aead.NonceSize()], body[c.aead.NonceSize():]
+		body, err = c.aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+	}
+	if len(body) < 8 {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() > int64(binary.BigEndian.Uint64(body[:8])) {
+		return nil, ErrExpiredToken
+	}
+	return body[8:], nil
+}
+
+// mac authenticates the token bytes together with the scope, each scope field length-prefixed so that fields cannot be shifted into each other.
+func (c *Codec) mac(scope Scope, data []byte) []byte {
+	var buf bytes.Buffer
+	for _, field := range [][]byte{[]byte(scope.TenantID), []byte(scope.TableHash), []byte(scope.Index), []byte(scope.Operation), scope.Condition} {
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(field))))
+		buf.Write(field)
+	}
+	buf.Write(data)
+	h := hmac.New(sha256.New, c.macKey)
+	h.Write(buf.Bytes())
+	return h.Sum(nil)
+}
@@ -3,7 +3,6 @@ package storage
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"strings"
@@ -27,9 +26,11 @@ type DynamoClient struct {
 	TableConfigTable  string
 	TenantConfigTable string
 	APIKeysTable      string
+	OutboxTable       string
+	IdempotencyTable  string
 }
 
-func NewDynamoClient(ctx context.Context, objectsTable string, indexesTable string, tableConfigTable string, tenantConfigTable string, apiKeysTable string, cfg aws.Config, useLocalstack bool, localstackUrl string) *DynamoClient {
+func NewDynamoClient(ctx context.Context, objectsTable string, indexesTable string, tableConfigTable string, tenantConfigTable string, apiKeysTable string, outboxTable string, idempotencyTable string, cfg aws.Config, useLocalstack bool, localstackUrl string) *DynamoClient {
 	return &DynamoClient{
 		Client: dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
 			if useLocalstack {
@@ -41,6 +42,8 @@ func NewDynamoClient(ctx context.Context, objectsTable string, indexesTable stri
 		TableConfigTable:  tableConfigTable,
 		TenantConfigTable: tenantConfigTable,
 		APIKeysTable:      apiKeysTable,
+		OutboxTable:       outboxTable,
+		IdempotencyTable:  idempotencyTable,
 	}
 }
 
@@ -87,24 +90,28 @@ func (d *DynamoClient) SetWrappedTableIEK(ctx context.Context, tenantId string,
 /*
 CreateObjectWithIndexes stores the given object in DynamoDB and creates associated index entries.
 It first marshals the object and index data into DynamoDB attribute maps.
-If the total number of items to write (object + indexes) is 25 or fewer, it performs a single transactional write.
-Otherwise, it writes the object separately and batches the index writes in groups of 25 using BatchWriteItem.
-Returns an error if any DynamoDB operation fails.
+If the object and its indexes fit in a single transaction (100 items), it performs a single transactional write.
+Otherwise, it follows the staged protocol: the object is written in pending status, the indexes are batch written, and the object is then flipped to its final status.
+Readers skip pending objects, so they never see an object whose indexes are only partially written. If the write is interrupted, the outbox worker
+completes it once its lease expires, see SettleStagedWrite.
+Returns ErrAlreadyExists if the object already exists, or an error if any DynamoDB operation fails.
 */
 func (d *DynamoClient) CreateObjectWithIndexes(ctx context.Context, tableHash string, obj *models.Object, indexes []objects.Index) error {
-	objMap, err := attributevalue.MarshalMap(obj)
-	if err != nil {
-		return err
+	var indexShards map[string]int
+	if len(indexes) > 0 {
+		var err error
+		indexShards, err = d.GetIndexShards(ctx, obj.GetTenantID(), tableHash)
+		if err != nil {
+			return err
+		}
 	}
-
 	indexItems := make([]map[string]ddbTypes.AttributeValue, 0, len(indexes))
 	for _, idx := range indexes {
-		objIdBytes, err := uuid.Parse(obj.GetObjectID())
+		token, err := objectIndexToken(idx, obj.GetObjectID())
 		if err != nil {
-			return fmt.Errorf("failed to parse object ID as UUID: %v", err)
+			return err
 		}
-		token := append(idx.GetIndexToken(), objIdBytes[:16]...) // append object ID to the index token to ensure uniqueness across objects with the same index values
-		index := models.NewIndex(idx.GetIndexName(), obj.GetTenantID(), tableHash, token, obj.GetObjectID(), obj.S3Key)
+		index := models.NewIndex(idx.GetIndexName(), obj.GetTenantID(), tableHash, token, obj.GetObjectID(), obj.Version, obj.S3Key, indexShards[idx.GetIndexName()])
 		av, err := attributevalue.MarshalMap(index)
 		if err != nil {
 			return err
@@ -112,9 +119,21 @@ func (d *DynamoClient) CreateObjectWithIndexes(ctx context.Context, tableHash st
 		indexItems = append(indexItems, av)
 	}
 
-	// if total items to put is <= 25, use a single transact write
-	if len(indexItems) <= 25 {
-		twrite := make([]ddbTypes.TransactWriteItem, 0, len(indexItems)+1)
+	expiryTask, err := d.expiryTaskPut(obj)
+	if err != nil {
+		return err
+	}
+	expiryItems := 0
+	if expiryTask != nil {
+		expiryItems = 1
+	}
+
+	if fitsInOneTransaction(len(indexItems), expiryItems) {
+		objMap, err := attributevalue.MarshalMap(obj)
+		if err != nil {
+			return err
+		}
+		twrite := make([]ddbTypes.TransactWriteItem, 0, len(indexItems)+1+expiryItems)
 
 		// obj put
 		twrite = append(twrite, ddbTypes.TransactWriteItem{
@@ -139,37 +158,48 @@ func (d *DynamoClient) CreateObjectWithIndexes(ctx context.Context, tableHash st
 				},
 			})
 		}
+		if expiryTask != nil {
+			twrite = append(twrite, *expiryTask)
+		}
 
-		_, err = d.Client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-			TransactItems: twrite,
-		})
-		if err != nil {
-			var throughputErr *ddbTypes.ProvisionedThroughputExceededException
-			if errors.As(err, &throughputErr) {
-				// retry with backoff
-				const maxRetries = 3
-				var retryCount int
-				for retryCount < maxRetries {
-					time.Sleep(time.Duration(100*(1<<retryCount)) * time.Millisecond) // exponential backoff
-					_, err = d.Client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-						TransactItems: twrite,
-					})
-					if err == nil {
-						return nil
-					}
-				}
-			}
-			var condErr *ddbTypes.ConditionalCheckFailedException
-			if errors.As(err, &condErr) {
-				return ErrAlreadyExists
-			}
+		err = d.transactWrite(ctx, twrite)
+		if cancelledItemIndex(err, "ConditionalCheckFailed") >= 0 {
+			return ErrAlreadyExists
 		}
 		return err
 	}
 
-	// if more than 25 items, write the object first, then batch write the indexes
+	// too many items for a single transaction, write the object as pending first, then batch write the indexes and commit
 
-	_, err = d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+	// the expiry is scheduled first, so that it cannot be lost if the staged write is interrupted; it does nothing if the object is never written
+	if expiryTask != nil {
+		if _, err := d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: expiryTask.Put.TableName,
+			Item:      expiryTask.Put.Item,
+		}); err != nil {
+			return err
+		}
+	}
+
+	stagedCtx, cancel, stagedUntil, err := d.beginStagedWrite(ctx, obj, obj.Version)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	finalStatus := obj.Status
+	obj.Status = models.StatusPending
+	obj.StagedIndexes = stagedIndexes(indexes)
+	obj.StagedUntil = stagedUntil
+	objMap, err := attributevalue.MarshalMap(obj)
+	obj.Status = finalStatus
+	obj.StagedIndexes = nil
+	obj.StagedUntil = 0
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Client.PutItem(stagedCtx, &dynamodb.PutItemInput{
 		TableName: aws.String(d.ObjectsTable),
 		Item:      objMap,
 		ConditionExpression: aws.String(
@@ -184,34 +214,33 @@ func (d *DynamoClient) CreateObjectWithIndexes(ctx context.Context, tableHash st
 		return err
 	}
 
-	const batchSize = 25
-	for i := 0; i < len(indexItems); i += batchSize {
-		end := min(i+batchSize, len(indexItems))
-
-		writeRequests := make([]ddbTypes.WriteRequest, 0, end-i)
-		for _, item := range indexItems[i:end] {
-			writeRequests = append(writeRequests, ddbTypes.WriteRequest{
-				PutRequest: &ddbTypes.PutRequest{
-					Item: item,
-				},
-			})
-		}
-
-		_, err = d.Client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]ddbTypes.WriteRequest{
-				d.IndexesTable: writeRequests,
+	writeRequests := make([]ddbTypes.WriteRequest, 0, len(indexItems))
+	for _, item := range indexItems {
+		writeRequests = append(writeRequests, ddbTypes.WriteRequest{
+			PutRequest: &ddbTypes.PutRequest{
+				Item: item,
 			},
 		})
-		if err != nil {
-			return err
-		}
+	}
+	if err := d.batchWrite(stagedCtx, d.IndexesTable, writeRequests); err != nil {
+		return err
 	}
 
-	return nil
+	return d.commitStagedObject(stagedCtx, obj.PK, obj.SK, obj.Version, finalStatus, stagedUntil)
 }
 
+/*
+UpdateObjectWithIndexes applies applyFn to the current version of an object and replaces its index entries with the given ones.
+Index entries whose name is not in the new set are deleted, entries whose token changed are replaced, and entries are rewritten when the object's S3 key changes.
+If the object put and the index mutations fit in a single transaction (100 items), they are applied atomically with a condition on the current version.
+Otherwise, the object is flipped to pending status under the same condition, the index mutations are applied in batches,
+and the updated object is then written back, so readers never see a half-updated object. If the write is interrupted, the outbox worker
+undoes it once its lease expires, see SettleStagedWrite.
+If the table keeps history, the version being replaced is archived with its index entries, in the same transaction when it fits.
+Returns ErrNotFound, ErrNotFoundOrDeleted, ErrPending or ErrVersionMismatch if the object cannot be updated from currentVersion.
+*/
 func (d *DynamoClient) UpdateObjectWithIndexes(ctx context.Context, tenantId string, tableHash string, objectId string, currentVersion int32, applyFn func(*models.Object), indexes []objects.Index) (*models.Object, error) {
-	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId)
+	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId, false)
 	if err != nil {
 		return nil, err
 	}
@@ -221,133 +250,392 @@ func (d *DynamoClient) UpdateObjectWithIndexes(ctx context.Context, tenantId str
 	if obj.Status == models.StatusDeleted {
 		return nil, ErrNotFoundOrDeleted
 	}
+	if obj.Status == models.StatusPending {
+		return nil, ErrPending
+	}
 	if obj.Version != currentVersion {
 		return nil, ErrVersionMismatch
 	}
 
+	currentIndexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, objectId)
+	if err != nil {
+		return nil, err
+	}
+
+	tableConfig, err := d.getTableWriteConfig(ctx, tenantId, tableHash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	previous := *obj
 	applyFn(obj)
 
-	item, _ := attributevalue.MarshalMap(obj)
-	_, err = d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+	deletes, puts, err := diffIndexes(tenantId, tableHash, obj, currentIndexes, indexes, tableConfig.IndexShards)
+	if err != nil {
+		return nil, err
+	}
+	historyItem, err := d.historyPut(tableConfig, &previous, currentIndexes, now)
+	if err != nil {
+		return nil, err
+	}
+	expiryTask, err := d.expiryTaskPut(obj)
+	if err != nil {
+		return nil, err
+	}
+	// items written along with the object: the archived version and the expiry of the new one
+	var extraItems []ddbTypes.TransactWriteItem
+	if historyItem != nil {
+		extraItems = append(extraItems, *historyItem)
+	}
+	if expiryTask != nil {
+		extraItems = append(extraItems, *expiryTask)
+	}
+
+	versionCondition := map[string]ddbTypes.AttributeValue{
+		":current": &ddbTypes.AttributeValueMemberN{
+			Value: fmt.Sprintf("%d", currentVersion),
+		},
+		":ready": &ddbTypes.AttributeValueMemberS{Value: models.StatusReady},
+	}
+
+	if fitsInOneTransaction(len(deletes)+len(puts), len(extraItems)) {
+		item, err := attributevalue.MarshalMap(obj)
+		if err != nil {
+			return nil, err
+		}
+		twrite := make([]ddbTypes.TransactWriteItem, 0, 1+len(deletes)+len(puts)+len(extraItems))
+		twrite = append(twrite, ddbTypes.TransactWriteItem{
+			Put: &ddbTypes.Put{
+				TableName: aws.String(d.ObjectsTable),
+				Item:      item,
+				ConditionExpression: aws.String(
+					"attribute_exists(pk) AND attribute_exists(sk) AND #v = :current AND #s = :ready",
+				),
+				ExpressionAttributeNames: map[string]string{
+					"#v": "version",
+					"#s": "status",
+				},
+				ExpressionAttributeValues: versionCondition,
+			},
+		})
+		twrite = append(twrite, indexTransactItems(d.IndexesTable, deletes, puts)...)
+		twrite = append(twrite, extraItems...)
+
+		err = d.transactWrite(ctx, twrite)
+		if cancelledItemIndex(err, "ConditionalCheckFailed") == 0 {
+			return nil, d.updateConflict(ctx, tenantId, tableHash, objectId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// pruning is best effort, the next update of the object retries it
+		_ = d.pruneHistory(ctx, tenantId, tableHash, objectId, tableConfig.HistoryMaxVersions)
+		return obj, nil
+	}
+
+	// too many items for a single transaction, flip the object to pending, apply the index mutations and write the new version
+
+	// the version being replaced is archived first; if the update then fails it is still the current version, which history readers skip,
+	// and a later update archives it again under the same key
+	if historyItem != nil {
+		if _, err := d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: historyItem.Put.TableName,
+			Item:      historyItem.Put.Item,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	// the expiry of the new version is scheduled first as well, it does nothing if the update fails
+	if expiryTask != nil {
+		if _, err := d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: expiryTask.Put.TableName,
+			Item:      expiryTask.Put.Item,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	stagedCtx, cancel, stagedUntil, err := d.beginStagedWrite(ctx, obj, currentVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	// the entries of the version being replaced, reinstated if the update is interrupted
+	snapshot, err := attributevalue.Marshal(versionIndexes(currentIndexes))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.Client.UpdateItem(stagedCtx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(d.ObjectsTable),
-		Item:      item,
-		ConditionExpression: aws.String(
-			"attribute_exists(pk) AND attribute_exists(sk) AND #v = :current AND #s <> :deleted",
-		),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: obj.PK},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: obj.SK},
+		},
+		UpdateExpression:    aws.String("SET #s = :pending, staged_indexes = :staged, staged_until = :stagedUntil"),
+		ConditionExpression: aws.String("#v = :current AND #s = :ready"),
 		ExpressionAttributeNames: map[string]string{
 			"#v": "version",
 			"#s": "status",
 		},
 		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
-			":current": &ddbTypes.AttributeValueMemberN{
-				Value: fmt.Sprintf("%d", currentVersion),
-			},
-			":deleted": &ddbTypes.AttributeValueMemberS{Value: models.StatusDeleted},
+			":current":     versionCondition[":current"],
+			":ready":       versionCondition[":ready"],
+			":pending":     &ddbTypes.AttributeValueMemberS{Value: models.StatusPending},
+			":staged":      snapshot,
+			":stagedUntil": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", stagedUntil)},
 		},
 	})
 	if err != nil {
 		var condErr *ddbTypes.ConditionalCheckFailedException
 		if errors.As(err, &condErr) {
-			return nil, ErrVersionMismatch
+			return nil, d.updateConflict(ctx, tenantId, tableHash, objectId)
 		}
 		return nil, err
 	}
 
-	if err := d.updateIndexes(ctx, tenantId, tableHash, objectId, indexes, obj.S3Key); err != nil {
+	if err := d.batchWrite(stagedCtx, d.IndexesTable, indexWriteRequests(deletes, puts)); err != nil {
+		return nil, err
+	}
+
+	item, err := attributevalue.MarshalMap(obj)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.Client.PutItem(stagedCtx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.ObjectsTable),
+		Item:                item,
+		ConditionExpression: aws.String("#v = :current AND #s = :pending AND staged_until = :stagedUntil"),
+		ExpressionAttributeNames: map[string]string{
+			"#v": "version",
+			"#s": "status",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":current":     versionCondition[":current"],
+			":pending":     &ddbTypes.AttributeValueMemberS{Value: models.StatusPending},
+			":stagedUntil": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", stagedUntil)},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return nil, ErrVersionMismatch
+		}
 		return nil, err
 	}
 
+	if historyItem != nil {
+		_ = d.pruneHistory(ctx, tenantId, tableHash, objectId, tableConfig.HistoryMaxVersions)
+	}
+
 	return obj, nil
 }
 
-func (d *DynamoClient) updateIndexes(ctx context.Context, tenantId string, tableHash string, objectId string, indexes []objects.Index, s3Key string) error {
-	currentIndexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, objectId)
-	if err != nil {
-		return err
-	}
-	indexMap := make(map[string][]byte)
+/*
+diffIndexes computes the index mutations needed to move an object from its current index entries to the given ones.
+Entries whose name is not in the new set, or whose token or partition changed, are deleted. New entries, changed entries,
+and entries whose duplicated S3 key no longer matches the object are put.
+*/
+func diffIndexes(tenantId string, tableHash string, obj *models.Object, currentIndexes map[string]models.Index, indexes []objects.Index, indexShards map[string]int) ([]models.Index, []models.Index, error) {
+	objectId := obj.GetObjectID()
+	newIndexes := make(map[string]*models.Index, len(indexes))
 	for _, idx := range indexes {
-		var name string
-		if idx.Name.RangeField != nil {
-			name = fmt.Sprintf("%s:%s", idx.Name.HashField, *idx.Name.RangeField)
-		} else {
-			name = idx.Name.HashField
-		}
-		objIdBytes, err := uuid.Parse(objectId)
+		token, err := objectIndexToken(idx, objectId)
 		if err != nil {
-			return fmt.Errorf("failed to parse object ID as UUID: %v", err)
+			return nil, nil, err
 		}
-		indexMap[name] = append(idx.GetIndexToken(), objIdBytes[:16]...) // append object ID to the index token to ensure uniqueness across objects with the same index values
+		name := idx.GetIndexName()
+		newIndexes[name] = models.NewIndex(name, tenantId, tableHash, token, objectId, obj.Version, obj.S3Key, indexShards[name])
 	}
 
-	for _, idx := range currentIndexes {
-		newIdx, exists := indexMap[idx.GetIndexName()]
+	var deletes []models.Index
+	var puts []models.Index
+	for name, current := range currentIndexes {
+		next, exists := newIndexes[name]
+		if !exists || next.PK != current.PK || !bytes.Equal(next.GetToken(), current.GetToken()) {
+			deletes = append(deletes, current)
+		}
+	}
+	for name, next := range newIndexes {
+		if current, exists := currentIndexes[name]; exists && next.PK == current.PK && bytes.Equal(next.GetToken(), current.GetToken()) && current.S3Key == obj.S3Key {
+			continue
+		}
+		puts = append(puts, *next)
+	}
+	return deletes, puts, nil
+}
 
-		// if index doesn't exist in the new set, delete it
-		if !exists {
-			_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-				TableName: aws.String(d.IndexesTable),
-				Key: map[string]ddbTypes.AttributeValue{
-					"pk": &ddbTypes.AttributeValueMemberS{Value: idx.PK},
-					"sk": &ddbTypes.AttributeValueMemberB{Value: idx.SK},
+func indexTransactItems(indexesTable string, deletes []models.Index, puts []models.Index) []ddbTypes.TransactWriteItem {
+	twrite := make([]ddbTypes.TransactWriteItem, 0, len(deletes)+len(puts))
+	for _, req := range indexWriteRequests(deletes, puts) {
+		if req.DeleteRequest != nil {
+			twrite = append(twrite, ddbTypes.TransactWriteItem{
+				Delete: &ddbTypes.Delete{
+					TableName: aws.String(indexesTable),
+					Key:       req.DeleteRequest.Key,
 				},
 			})
-			if err != nil {
-				return err
-			}
 			continue
 		}
+		twrite = append(twrite, ddbTypes.TransactWriteItem{
+			Put: &ddbTypes.Put{
+				TableName: aws.String(indexesTable),
+				Item:      req.PutRequest.Item,
+			},
+		})
+	}
+	return twrite
+}
 
-		// if index exists but token has changed, update it
-		if !bytes.Equal(newIdx, idx.GetToken()) {
-			_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-				TableName: aws.String(d.IndexesTable),
+func indexWriteRequests(deletes []models.Index, puts []models.Index) []ddbTypes.WriteRequest {
+	requests := make([]ddbTypes.WriteRequest, 0, len(deletes)+len(puts))
+	for _, idx := range deletes {
+		requests = append(requests, ddbTypes.WriteRequest{
+			DeleteRequest: &ddbTypes.DeleteRequest{
 				Key: map[string]ddbTypes.AttributeValue{
 					"pk": &ddbTypes.AttributeValueMemberS{Value: idx.PK},
 					"sk": &ddbTypes.AttributeValueMemberB{Value: idx.SK},
 				},
-				UpdateExpression: aws.String("SET sk = :newToken, updated_at = :updatedAt"),
-				ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
-					":newToken":  &ddbTypes.AttributeValueMemberB{Value: newIdx},
-					":updatedAt": &ddbTypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
-				},
-			})
-			if err != nil {
-				return err
-			}
+			},
+		})
+	}
+	for _, idx := range puts {
+		// models.Index only holds marshalable fields, the error can be ignored
+		item, _ := attributevalue.MarshalMap(idx)
+		requests = append(requests, ddbTypes.WriteRequest{
+			PutRequest: &ddbTypes.PutRequest{
+				Item: item,
+			},
+		})
+	}
+	return requests
+}
+
+/*
+UpsertIndexes writes index entries for an existing object version without touching the object item or its blob.
+Index entries that already hold the same token for the same version are left untouched, so the call can be safely repeated by a resumable backfill.
+Entries whose token or partition changed are replaced, and entries for index names not present in the request are kept.
+Every transaction carries a condition check on the object version, so the write fails with ErrVersionMismatch if the object is updated concurrently.
+Returns the number of index entries that were created or replaced.
+*/
+func (d *DynamoClient) UpsertIndexes(ctx context.Context, tenantId string, tableHash string, objectId string, version int32, indexes []objects.Index) (int, error) {
+	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId, false)
+	if err != nil {
+		return 0, err
+	}
+	if obj == nil {
+		return 0, ErrNotFound
+	}
+	if obj.Status == models.StatusDeleted {
+		return 0, ErrNotFoundOrDeleted
+	}
+	if obj.Status == models.StatusPending {
+		return 0, ErrPending
+	}
+	if obj.Version != version {
+		return 0, ErrVersionMismatch
+	}
+
+	currentIndexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, objectId)
+	if err != nil {
+		return 0, err
+	}
+	indexShards, err := d.GetIndexShards(ctx, tenantId, tableHash)
+	if err != nil {
+		return 0, err
+	}
+
+	// each index may need a delete and a put, plus one condition check per transaction
+	const indexesPerTransaction = 49
+	conditionCheck := ddbTypes.TransactWriteItem{
+		ConditionCheck: &ddbTypes.ConditionCheck{
+			TableName: aws.String(d.ObjectsTable),
+			Key: map[string]ddbTypes.AttributeValue{
+				"pk": &ddbTypes.AttributeValueMemberS{Value: obj.PK},
+				"sk": &ddbTypes.AttributeValueMemberS{Value: obj.SK},
+			},
+			ConditionExpression: aws.String("#v = :version AND #s = :ready"),
+			ExpressionAttributeNames: map[string]string{
+				"#v": "version",
+				"#s": "status",
+			},
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":version": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", version)},
+				":ready":   &ddbTypes.AttributeValueMemberS{Value: models.StatusReady},
+			},
+		},
+	}
+
+	written := 0
+	twrite := []ddbTypes.TransactWriteItem{conditionCheck}
+	pending := 0
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		err := d.transactWrite(ctx, twrite)
+		if cancelledItemIndex(err, "ConditionalCheckFailed") == 0 {
+			return ErrVersionMismatch
+		}
+		if err != nil {
+			return err
 		}
+		written += pending
+		twrite = []ddbTypes.TransactWriteItem{conditionCheck}
+		pending = 0
+		return nil
 	}
 
-	// add new indexes that didn't exist before
 	for _, idx := range indexes {
-		var idxName string
-		if idx.Name.RangeField != nil {
-			idxName = fmt.Sprintf("%s:%s", idx.Name.HashField, *idx.Name.RangeField)
-		} else {
-			idxName = idx.Name.HashField
-		}
-		if _, exists := currentIndexes[idxName]; exists {
+		if idx.IsNil() {
 			continue
 		}
-		objIdBytes, err := uuid.Parse(objectId)
+		name := idx.GetIndexName()
+		token, err := objectIndexToken(idx, objectId)
 		if err != nil {
-			return fmt.Errorf("failed to parse object ID as UUID: %v", err)
+			return written, err
 		}
-		newIndex := models.NewIndex(idxName, tenantId, tableHash, append(idx.GetIndexToken(), objIdBytes[:16]...), objectId, s3Key)
-		item, err := attributevalue.MarshalMap(newIndex)
+		index := models.NewIndex(name, tenantId, tableHash, token, objectId, version, obj.S3Key, indexShards[name])
+		if current, exists := currentIndexes[name]; exists {
+			samePlace := current.PK == index.PK && bytes.Equal(current.GetToken(), token)
+			if samePlace && current.ObjectVersion == version && current.S3Key == obj.S3Key {
+				continue
+			}
+			if !samePlace {
+				twrite = append(twrite, ddbTypes.TransactWriteItem{
+					Delete: &ddbTypes.Delete{
+						TableName: aws.String(d.IndexesTable),
+						Key: map[string]ddbTypes.AttributeValue{
+							"pk": &ddbTypes.AttributeValueMemberS{Value: current.PK},
+							"sk": &ddbTypes.AttributeValueMemberB{Value: current.SK},
+						},
+					},
+				})
+			}
+		}
+		item, err := attributevalue.MarshalMap(index)
 		if err != nil {
-			return err
+			return written, err
 		}
-		_, err = d.Client.PutItem(ctx, &dynamodb.PutItemInput{
-			TableName: aws.String(d.IndexesTable),
-			Item:      item,
+		twrite = append(twrite, ddbTypes.TransactWriteItem{
+			Put: &ddbTypes.Put{
+				TableName: aws.String(d.IndexesTable),
+				Item:      item,
+			},
 		})
-		if err != nil {
-			return err
+		pending++
+		if pending == indexesPerTransaction {
+			if err := flush(); err != nil {
+				return written, err
+			}
 		}
 	}
+	if err := flush(); err != nil {
+		return written, err
+	}
 
-	return nil
+	return written, nil
 }
 
 func (d *DynamoClient) GetIndexesByObjectID(ctx context.Context, tenantId string, tableHash string, objectId string) (map[string]models.Index, error) {
@@ -375,9 +663,10 @@ func (d *DynamoClient) GetIndexesByObjectID(ctx context.Context, tenantId string
 }
 
 /*
-GetObject retrieves an object by tenant ID and object ID from DynamoDB.
+GetObject retrieves an object by tenant ID and object ID from DynamoDB, with a strongly consistent read if consistentRead is true.
+An expired object is reported as missing, even if its expiry task has not removed it yet.
 */
-func (d *DynamoClient) GetObject(ctx context.Context, tenantId string, tableHash string, objectId string) (*models.Object, error) {
+func (d *DynamoClient) GetObject(ctx context.Context, tenantId string, tableHash string, objectId string, consistentRead bool) (*models.Object, error) {
 	pk := models.GenerateObjectPK(tenantId, tableHash)
 	sk := models.GenerateObjectSK(objectId)
 
@@ -387,6 +676,7 @@ func (d *DynamoClient) GetObject(ctx context.Context, tenantId string, tableHash
 			"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
 			"sk": &ddbTypes.AttributeValueMemberS{Value: sk},
 		},
+		ConsistentRead: aws.Bool(consistentRead),
 	})
 
 	if err != nil {
@@ -400,6 +690,9 @@ func (d *DynamoClient) GetObject(ctx context.Context, tenantId string, tableHash
 	if err := attributevalue.UnmarshalMap(out.Item, &obj); err != nil {
 		return nil, err
 	}
+	if obj.IsExpired(time.Now()) {
+		return nil, nil
+	}
 
 	return &obj, nil
 }
@@ -567,110 +860,123 @@ func (d *DynamoClient) FindAPIKey(ctx context.Context, tenantId string, provided
 }
 
 type QueryResult struct {
-	Objects   []models.Object
-	Count     int
-	NextToken *string
+	Objects []models.Object
+	Count   int // number of objects returned
+	// number of items read to collect them, deleted, pending and missing objects included
+	ScannedCount int
+	// position to resume from, nil if there are no more results; it must be sealed before being handed out to clients
+	NextCursor []byte
 }
 
 type ScanResult = QueryResult
 
-func (d *DynamoClient) ScanTable(ctx context.Context, tenantID string, tableHash string, limit int, nextToken string) (*ScanResult, error) {
-	var dynamoLimit *int32
-	if limit > 0 {
-		l := int32(limit)
-		dynamoLimit = &l
+// Maximum number of DynamoDB pages read to fill a single Scan or Query page
+const maxPageReads = 10
+
+// objectMetadataProjection reads an object without its blob, for metadata-only reads; #status must be mapped to status.
+const objectMetadataProjection = "pk, sk, encrypted_metadata, kms_wrapped_dek, master_wrapped_dek, dek_nonce, sensitivity, created_at, updated_at, version, #status, expires_at"
+
+/*
+ScanTable returns up to limit ready objects of a table, in object ID order.
+DynamoDB applies Limit before the status filter, so pages are read until limit ready objects are collected, the table is exhausted, or the read budget runs out.
+In the last case fewer objects are returned along with a cursor. With no limit, a single DynamoDB page is returned unless it holds no ready object.
+If consistentRead is true, pages are read with strongly consistent reads. If metadataOnly is true, objects are read without their blob and S3 key.
+*/
+func (d *DynamoClient) ScanTable(ctx context.Context, tenantID string, tableHash string, limit int, cursor []byte, consistentRead bool, metadataOnly bool) (*ScanResult, error) {
+	return d.scanObjectsByStatus(ctx, tenantID, tableHash, models.StatusReady, limit, cursor, consistentRead, metadataOnly)
+}
+
+// scanObjectsByStatus reads the objects of a table with the given status, with the paging rules of ScanTable.
+func (d *DynamoClient) scanObjectsByStatus(ctx context.Context, tenantID string, tableHash string, status string, limit int, cursor []byte, consistentRead bool, metadataOnly bool) (*ScanResult, error) {
+	if cursor != nil && !bytes.HasPrefix(cursor, []byte("OBJ#")) {
+		return nil, ErrInvalidCursor
 	}
 	pk := models.GenerateObjectPK(tenantID, tableHash)
-	out, err := d.Client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(d.ObjectsTable),
-		KeyConditionExpression: aws.String("pk = :pk"),
-		FilterExpression:       aws.String("#status <> :deleted"),
-		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
-			":pk":      &ddbTypes.AttributeValueMemberS{Value: pk},
-			":deleted": &ddbTypes.AttributeValueMemberS{Value: models.StatusDeleted},
-		},
-		ExpressionAttributeNames: map[string]string{
-			"#status": "status",
-		},
-		Limit: dynamoLimit,
-		ExclusiveStartKey: func() map[string]ddbTypes.AttributeValue {
-			if nextToken == "" {
-				return nil
-			}
-			return map[string]ddbTypes.AttributeValue{
+	result := &ScanResult{Objects: []models.Object{}}
+	for reads := 0; reads < maxPageReads; reads++ {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(d.ObjectsTable),
+			KeyConditionExpression: aws.String("pk = :pk"),
+			FilterExpression:       aws.String("#status = :status AND " + notExpiredFilter),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":pk":     &ddbTypes.AttributeValueMemberS{Value: pk},
+				":status": &ddbTypes.AttributeValueMemberS{Value: status},
+				":now":    &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+			},
+			ExpressionAttributeNames: map[string]string{
+				"#status": "status",
+			},
+			ConsistentRead: aws.Bool(consistentRead),
+		}
+		if metadataOnly {
+			input.ProjectionExpression = aws.String(objectMetadataProjection)
+		}
+		if limit > 0 {
+			input.Limit = aws.Int32(int32(limit - len(result.Objects)))
+		}
+		if cursor != nil {
+			input.ExclusiveStartKey = map[string]ddbTypes.AttributeValue{
 				"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
-				"sk": &ddbTypes.AttributeValueMemberS{Value: nextToken},
+				"sk": &ddbTypes.AttributeValueMemberS{Value: string(cursor)},
 			}
-		}(),
-	})
-	if err != nil {
-		return nil, err
-	}
-	objects := make([]models.Object, 0, len(out.Items))
-	for _, item := range out.Items {
-		var obj models.Object
-		if err := attributevalue.UnmarshalMap(item, &obj); err != nil {
+		}
+		out, err := d.Client.Query(ctx, input)
+		if err != nil {
 			return nil, err
 		}
-		objects = append(objects, obj)
-	}
-	var lastEvalKey *string
-	if out.LastEvaluatedKey != nil {
+		result.ScannedCount += int(out.ScannedCount)
+		for _, item := range out.Items {
+			var obj models.Object
+			if err := attributevalue.UnmarshalMap(item, &obj); err != nil {
+				return nil, err
+			}
+			result.Objects = append(result.Objects, obj)
+		}
+
+		cursor = nil
 		if sk, ok := out.LastEvaluatedKey["sk"].(*ddbTypes.AttributeValueMemberS); ok {
-			lastEvalKey = &sk.Value
+			cursor = []byte(sk.Value)
+		}
+		if cursor == nil || (limit > 0 && len(result.Objects) == limit) || (limit <= 0 && len(result.Objects) > 0) {
+			break
 		}
 	}
-	return &ScanResult{
-		Objects:   objects,
-		NextToken: lastEvalKey,
-		Count:     int(out.Count),
-	}, nil
+	result.Count = len(result.Objects)
+	result.NextCursor = cursor
+	return result, nil
 }
 
-func (d *DynamoClient) SoftDeleteObjectAndIndexes(ctx context.Context, tenantId string, tableHash string, objectId string) (*string, error) {
-	pk := models.GenerateObjectPK(tenantId, tableHash)
-	sk := models.GenerateObjectSK(objectId)
-
-	now := time.Now().UTC()
-	ttl := now.Add(30 * 24 * time.Hour).Unix() // delete after 30 days
-
-	out, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(d.ObjectsTable),
-		Key: map[string]ddbTypes.AttributeValue{
-			"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
-			"sk": &ddbTypes.AttributeValueMemberS{Value: sk},
-		},
-		UpdateExpression:    aws.String("SET #status = :deleted, updated_at = :now, #v = #v + :inc, #ttl = :ttl"),
-		ConditionExpression: aws.String("attribute_exists(pk) AND #status <> :deleted"),
-		ExpressionAttributeNames: map[string]string{
-			"#status": "status",
-			"#v":      "version",
-			"#ttl":    "ttl",
-		},
-		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
-			":deleted": &ddbTypes.AttributeValueMemberS{Value: models.StatusDeleted},
-			":now":     &ddbTypes.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
-			":inc":     &ddbTypes.AttributeValueMemberN{Value: "1"},
-			":ttl":     &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", ttl)},
-		},
-		ReturnValues: "ALL_NEW",
-	})
-
+/*
+SoftDeleteObjectAndIndexes marks an object as deleted and enqueues the removal of its index entries.
+The status change and the outbox task are written in a single transaction, so the index cleanup is guaranteed to happen eventually
+even if the API server stops right after the delete; the outbox worker takes care of it.
+The object item expires retentionDays after the delete, see SoftDeleteRetentionDays.
+*/
+func (d *DynamoClient) SoftDeleteObjectAndIndexes(ctx context.Context, tenantId string, tableHash string, objectId string, retentionDays int) (*string, error) {
+	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId, true)
 	if err != nil {
-		var condErr *ddbTypes.ConditionalCheckFailedException
-		if errors.As(err, &condErr) {
-			return nil, ErrNotFoundOrDeleted
-		}
 		return nil, err
 	}
+	if obj == nil || obj.Status == models.StatusDeleted {
+		return nil, ErrNotFoundOrDeleted
+	}
 
-	var obj models.Object
-	err = attributevalue.UnmarshalMap(out.Attributes, &obj)
+	indexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, objectId)
 	if err != nil {
 		return nil, err
 	}
-
-	go d.deleteIndexesByObject(ctx, tenantId, tableHash, objectId)
+	twrite, err := d.softDeleteItems(obj, indexes, retentionDays, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	err = d.transactWrite(ctx, twrite)
+	if err != nil {
+		if cancelledItemIndex(err, "ConditionalCheckFailed") == 0 {
+			// the object was modified or deleted between the read and the transaction
+			return nil, ErrVersionMismatch
+		}
+		return nil, err
+	}
 
 	if obj.S3Key != "" {
 		return &obj.S3Key, nil
@@ -678,6 +984,60 @@ func (d *DynamoClient) SoftDeleteObjectAndIndexes(ctx context.Context, tenantId
 	return nil, nil
 }
 
+/*
+softDeleteItems returns the transaction items that mark obj as deleted, conditioned on its current version, and enqueue the cleanup of its index entries.
+The given index entries are snapshotted on the object item, so that UndeleteObject can reinstate them.
+The object item expires retentionDays after now, when the outbox purges it along with its blobs; with zero retention the purge is already due.
+*/
+func (d *DynamoClient) softDeleteItems(obj *models.Object, indexes map[string]models.Index, retentionDays int, now time.Time) ([]ddbTypes.TransactWriteItem, error) {
+	ttl := now.AddDate(0, 0, retentionDays).Unix()
+
+	snapshot, err := attributevalue.Marshal(versionIndexes(indexes))
+	if err != nil {
+		return nil, err
+	}
+
+	task := models.NewOutboxTask(uuid.NewString(), models.OutboxTaskDeleteIndexes, obj.GetTenantID(), obj.GetTableHash(), obj.GetObjectID(), obj.Version+1, now)
+	taskPut, err := d.outboxTaskPut(task)
+	if err != nil {
+		return nil, err
+	}
+	purgeTask := models.NewOutboxTask(uuid.NewString(), models.OutboxTaskPurgeDeleted, obj.GetTenantID(), obj.GetTableHash(), obj.GetObjectID(), obj.Version+1, time.Unix(ttl, 0))
+	purgePut, err := d.outboxTaskPut(purgeTask)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ddbTypes.TransactWriteItem{
+		{
+			Update: &ddbTypes.Update{
+				TableName: aws.String(d.ObjectsTable),
+				Key: map[string]ddbTypes.AttributeValue{
+					"pk": &ddbTypes.AttributeValueMemberS{Value: obj.PK},
+					"sk": &ddbTypes.AttributeValueMemberS{Value: obj.SK},
+				},
+				UpdateExpression:    aws.String("SET #status = :deleted, updated_at = :now, #v = #v + :inc, #ttl = :ttl, deleted_indexes = :indexes"),
+				ConditionExpression: aws.String("attribute_exists(pk) AND #status <> :deleted AND #v = :version"),
+				ExpressionAttributeNames: map[string]string{
+					"#status": "status",
+					"#v":      "version",
+					"#ttl":    "ttl",
+				},
+				ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+					":deleted": &ddbTypes.AttributeValueMemberS{Value: models.StatusDeleted},
+					":now":     &ddbTypes.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+					":inc":     &ddbTypes.AttributeValueMemberN{Value: "1"},
+					":ttl":     &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", ttl)},
+					":version": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", obj.Version)},
+					":indexes": snapshot,
+				},
+			},
+		},
+		taskPut,
+		purgePut,
+	}, nil
+}
+
 func (d *DynamoClient) deleteIndexesByObject(ctx context.Context, tenantId string, tableHash string, objectId string) error {
 	indexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, objectId)
 	if err != nil {
@@ -687,45 +1047,54 @@ func (d *DynamoClient) deleteIndexesByObject(ctx context.Context, tenantId strin
 	for _, idx := range indexes {
 		idxs = append(idxs, idx)
 	}
+	return d.batchDeleteIndexes(ctx, idxs)
+}
 
-	for i := 0; i < len(idxs); i += 25 {
-		end := min(i+25, len(idxs))
-		writeRequests := make([]ddbTypes.WriteRequest, 0, end-i)
-		for _, idx := range idxs[i:end] {
-			writeRequests = append(writeRequests, ddbTypes.WriteRequest{
-				DeleteRequest: &ddbTypes.DeleteRequest{
-					Key: map[string]ddbTypes.AttributeValue{
-						"pk": &ddbTypes.AttributeValueMemberS{Value: idx.PK},
-						"sk": &ddbTypes.AttributeValueMemberB{Value: idx.SK},
-					},
-				},
-			})
-		}
-		_, err := d.Client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]ddbTypes.WriteRequest{
-				d.IndexesTable: writeRequests,
-			},
-		})
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+// batchDeleteIndexes deletes the given index entries, retrying unprocessed items.
+func (d *DynamoClient) batchDeleteIndexes(ctx context.Context, idxs []models.Index) error {
+	return d.batchWrite(ctx, d.IndexesTable, indexWriteRequests(idxs, nil))
 }
 
+/*
+UndeleteObject recovers a soft-deleted object and reinstates the index entries snapshotted when it was deleted.
+Entries are rebuilt for the new version and the table's current index shards, so an index sharded while the object was in the trash is honored.
+If the object and its entries fit in a single transaction (100 items), the status change and the entry puts are applied atomically;
+an entry put fails if its key is held by an entry of another object. Otherwise the entries are written first, which readers ignore
+while the object is deleted, and the object is then flipped back to ready.
+Returns ErrNotFoundOrDeleted if the object is not soft-deleted, ErrVersionMismatch if it changed concurrently, or ErrIndexConflict
+if one of its entries can no longer be reinstated.
+*/
 func (d *DynamoClient) UndeleteObject(ctx context.Context, tenantId string, tableHash string, objectId string) (*string, error) {
-	pk := models.GenerateObjectPK(tenantId, tableHash)
-	sk := models.GenerateObjectSK(objectId)
+	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId, true)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil || obj.Status != models.StatusDeleted {
+		return nil, ErrNotFoundOrDeleted
+	}
+	tableConfig, err := d.getTableWriteConfig(ctx, tenantId, tableHash)
+	if err != nil {
+		return nil, err
+	}
 
-	out, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	recoveredVersion := obj.Version + 1
+	indexes := make([]models.Index, 0, len(obj.DeletedIndexes))
+	for _, snapshot := range obj.DeletedIndexes {
+		token, err := objectIndexToken(versionIndexToIndex(snapshot), objectId)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, *models.NewIndex(snapshot.Name, tenantId, tableHash, token, objectId, recoveredVersion, obj.S3Key, tableConfig.IndexShards[snapshot.Name]))
+	}
+
+	recoverUpdate := &ddbTypes.Update{
 		TableName: aws.String(d.ObjectsTable),
 		Key: map[string]ddbTypes.AttributeValue{
-			"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
-			"sk": &ddbTypes.AttributeValueMemberS{Value: sk},
+			"pk": &ddbTypes.AttributeValueMemberS{Value: obj.PK},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: obj.SK},
 		},
-		UpdateExpression:    aws.String("SET #status = :ready, updated_at = :now, #v = #v + :inc REMOVE #ttl"),
-		ConditionExpression: aws.String("attribute_exists(pk) AND #status = :deleted"),
+		UpdateExpression:    aws.String("SET #status = :ready, updated_at = :now, #v = #v + :inc REMOVE #ttl, deleted_indexes"),
+		ConditionExpression: aws.String("#status = :deleted AND #v = :version"),
 		ExpressionAttributeNames: map[string]string{
 			"#status": "status",
 			"#v":      "version",
@@ -736,208 +1105,197 @@ func (d *DynamoClient) UndeleteObject(ctx context.Context, tenantId string, tabl
 			":ready":   &ddbTypes.AttributeValueMemberS{Value: models.StatusReady},
 			":now":     &ddbTypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
 			":inc":     &ddbTypes.AttributeValueMemberN{Value: "1"},
+			":version": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", obj.Version)},
 		},
-		ReturnValues: "ALL_NEW",
-	})
-	if err != nil {
-		var condErr *ddbTypes.ConditionalCheckFailedException
-		if errors.As(err, &condErr) {
-			return nil, ErrNotFoundOrDeleted
-		}
-		return nil, err
 	}
-	var obj models.Object
-	err = attributevalue.UnmarshalMap(out.Attributes, &obj)
+
+	// an expiring object gets its TTL back, and its expiry is scheduled again for the recovered version
+	recovered := *obj
+	recovered.Version = recoveredVersion
+	expiryTask, err := d.expiryTaskPut(&recovered)
 	if err != nil {
 		return nil, err
 	}
+	expiryItems := 0
+	if expiryTask != nil {
+		expiryItems = 1
+		recoverUpdate.UpdateExpression = aws.String("SET #status = :ready, updated_at = :now, #v = #v + :inc, #ttl = :expiresAt REMOVE deleted_indexes")
+		recoverUpdate.ExpressionAttributeValues[":expiresAt"] = &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", obj.ExpiresAt)}
+	}
+
+	if fitsInOneTransaction(len(indexes), expiryItems) {
+		twrite := make([]ddbTypes.TransactWriteItem, 0, 1+len(indexes)+expiryItems)
+		twrite = append(twrite, ddbTypes.TransactWriteItem{Update: recoverUpdate})
+		for _, req := range indexWriteRequests(nil, indexes) {
+			twrite = append(twrite, ddbTypes.TransactWriteItem{
+				Put: &ddbTypes.Put{
+					TableName: aws.String(d.IndexesTable),
+					Item:      req.PutRequest.Item,
+					// the entry may still be there if the outbox has not removed it yet, but it must belong to this object
+					ConditionExpression: aws.String("attribute_not_exists(pk) OR gsi1pk = :owner"),
+					ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+						":owner": &ddbTypes.AttributeValueMemberS{Value: models.GenerateGSI1PK(tenantId, tableHash, objectId)},
+					},
+				},
+			})
+		}
+		if expiryTask != nil {
+			twrite = append(twrite, *expiryTask)
+		}
+		err = d.transactWrite(ctx, twrite)
+		if failed := cancelledItemIndex(err, "ConditionalCheckFailed"); failed == 0 {
+			return nil, ErrVersionMismatch
+		} else if failed > 0 {
+			return nil, ErrIndexConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err := d.batchWrite(ctx, d.IndexesTable, indexWriteRequests(nil, indexes)); err != nil {
+			return nil, err
+		}
+		if expiryTask != nil {
+			if _, err := d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+				TableName: expiryTask.Put.TableName,
+				Item:      expiryTask.Put.Item,
+			}); err != nil {
+				return nil, err
+			}
+		}
+		_, err = d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 recoverUpdate.TableName,
+			Key:                       recoverUpdate.Key,
+			UpdateExpression:          recoverUpdate.UpdateExpression,
+			ConditionExpression:       recoverUpdate.ConditionExpression,
+			ExpressionAttributeNames:  recoverUpdate.ExpressionAttributeNames,
+			ExpressionAttributeValues: recoverUpdate.ExpressionAttributeValues,
+		})
+		if err != nil {
+			var condErr *ddbTypes.ConditionalCheckFailedException
+			if errors.As(err, &condErr) {
+				// the object changed in the meantime: a concurrent recover wrote the same entries and owns them now, so they are
+				// left in place; if the object was purged instead, readers skip them and index verification removes them
+				return nil, ErrVersionMismatch
+			}
+			return nil, err
+		}
+	}
+
 	if obj.S3Key != "" {
 		return &obj.S3Key, nil
 	}
 	return nil, nil
 }
 
-func (d *DynamoClient) QueryIndexes(ctx context.Context, tenantId string, tableHash string, index objects.Index, betweenRange [2][]byte, rangeOp objects.QueryOperator, limit int, nextToken string, scanForward bool) (*QueryResult, error) {
-	var dynamoLimit *int32
-	if limit > 0 {
-		l := int32(limit)
-		dynamoLimit = &l
-	}
-	var decodedNextToken []byte
+/*
+QueryIndexes returns up to limit ready objects whose index entries match the given index token and range operator, in sort key order.
+Index entries whose object is missing, deleted or pending are skipped, and more entries are read until limit objects are collected,
+the index is exhausted, or the read budget runs out; in the last case fewer objects are returned along with a cursor.
+Sharded indexes are queried on every partition and merged, their cursor holds a position per partition.
+If consistentRead is true, index partitions are read with strongly consistent reads; objects are always fetched with strongly consistent reads.
+If metadataOnly is true, objects are fetched without their blob and S3 key.
+Returns ErrInvalidCursor if the cursor does not match the index layout.
+*/
+func (d *DynamoClient) QueryIndexes(ctx context.Context, tenantId string, tableHash string, index objects.Index, betweenRange [2][]byte, rangeOp objects.QueryOperator, limit int, cursor []byte, scanForward bool, consistentRead bool, metadataOnly bool) (*QueryResult, error) {
 	if rangeOp == objects.RangeBetween {
 		if (betweenRange[0] == nil || betweenRange[1] == nil) || !index.IsHashOnly() {
 			return nil, fmt.Errorf("invalid range query: for RangeBetween operator, index must be hash-only and both betweenRange tokens must be non-nil")
 		}
 	}
-	if nextToken != "" {
-		var err error
-		decodedNextToken, err = base64.StdEncoding.DecodeString(nextToken)
-		if err != nil {
-			return nil, fmt.Errorf("invalid nextToken format: %w", err)
-		}
-		// validate token length based on index type
-		if index.Name.RangeField != nil && len(decodedNextToken) != models.IndexTokenHashAndRangeLength {
-			return nil, fmt.Errorf("invalid nextToken length for range index: expected %d, got %d", models.IndexTokenHashAndRangeLength, len(decodedNextToken))
-		}
-		if index.Name.RangeField == nil && len(decodedNextToken) != models.IndexTokenHashLength {
-			return nil, fmt.Errorf("invalid nextToken length for hash-only index: expected %d, got %d", models.IndexTokenHashLength, len(decodedNextToken))
-		}
+	tokenLength := models.IndexTokenHashLength
+	if index.Name.RangeField != nil {
+		tokenLength = models.IndexTokenHashAndRangeLength
 	}
 
-	var out *dynamodb.QueryOutput
-	var err error
+	keyCond, exprAttrValues, err := indexKeyCondition(index, betweenRange, rangeOp)
+	if err != nil {
+		return nil, err
+	}
 
-	// Query index table to get matching object IDs
-	if index.Name.RangeField == nil {
-		if !index.IsHashOnly() || betweenRange[0] != nil || betweenRange[1] != nil {
-			return nil, fmt.Errorf("invalid index: for hash-only index, token must be non-nil and betweenRange must be nil")
+	indexShards, err := d.GetIndexShards(ctx, tenantId, tableHash)
+	if err != nil {
+		return nil, err
+	}
+	shards := indexShards[index.GetIndexName()]
+	pk := models.GenerateIndexPK(tenantId, tableHash, index.GetIndexName())
+	if shards <= 1 && cursor != nil && len(cursor) != tokenLength {
+		// the cursor is the sort key of the last entry read
+		return nil, ErrInvalidCursor
+	}
+
+	result := &QueryResult{Objects: []models.Object{}}
+	for reads := 0; reads < maxPageReads; reads++ {
+		pageLimit := 0
+		if limit > 0 {
+			pageLimit = limit - len(result.Objects)
 		}
-		// hash-only index, query by pk + token prefix
-		pk := models.GenerateIndexPK(tenantId, tableHash, index.GetIndexName())
-		out, err = d.Client.Query(ctx, &dynamodb.QueryInput{
-			TableName:              aws.String(d.IndexesTable),
-			KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
-			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
-				":pk":     &ddbTypes.AttributeValueMemberS{Value: pk},
-				":prefix": &ddbTypes.AttributeValueMemberB{Value: index.TokenHash},
-			},
-			Limit:            dynamoLimit,
-			ScanIndexForward: aws.Bool(scanForward),
-			ExclusiveStartKey: func() map[string]ddbTypes.AttributeValue {
-				if decodedNextToken == nil {
-					return nil
-				}
-				return map[string]ddbTypes.AttributeValue{
-					"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
-					"sk": &ddbTypes.AttributeValueMemberB{Value: decodedNextToken},
+
+		// Query index table to get matching object IDs
+		var entries []models.Index
+		if shards > 1 {
+			pks := models.GenerateIndexPartitionPKs(tenantId, tableHash, index.GetIndexName(), shards)
+			entries, cursor, err = d.queryShardedIndex(ctx, pks, keyCond, exprAttrValues, pageLimit, cursor, tokenLength, scanForward, consistentRead)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			out, err := d.queryIndexPartition(ctx, pk, keyCond, exprAttrValues, pageLimit, cursor, scanForward, consistentRead)
+			if err != nil {
+				return nil, err
+			}
+			entries = make([]models.Index, 0, len(out.Items))
+			for _, item := range out.Items {
+				var idx models.Index
+				if err := attributevalue.UnmarshalMap(item, &idx); err != nil {
+					return nil, err
 				}
-			}(),
-		})
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		// hash+range index, handle different range queries by translating to DynamoDB syntax
-		if rangeOp == objects.RangeBetween {
-			if !index.IsHashOnly() {
-				return nil, fmt.Errorf("invalid index: for RangeBetween operator, index must be hash-only and betweenRange must be provided")
+				entries = append(entries, idx)
 			}
-			if len(betweenRange[0]) != models.OPERangeValueLength || len(betweenRange[1]) != models.OPERangeValueLength {
-				return nil, fmt.Errorf("invalid betweenRange token length for range index: expected %d, got %d and %d", models.OPERangeValueLength, len(betweenRange[0]), len(betweenRange[1]))
+			cursor = nil
+			if sk, ok := out.LastEvaluatedKey["sk"].(*ddbTypes.AttributeValueMemberB); ok {
+				cursor = sk.Value
 			}
 		}
-		pk := models.GenerateIndexPK(tenantId, tableHash, index.GetIndexName())
-
-		var keyCondExp string
-		type AttributeValueMap = map[string]ddbTypes.AttributeValue
-		var exprAttrValues AttributeValueMap = map[string]ddbTypes.AttributeValue{
-			":pk": &ddbTypes.AttributeValueMemberS{Value: pk},
-		}
-
-		lower := make([]byte, models.DETHashValueLength, models.IndexTokenHashAndRangeLength)
-		copy(lower, index.TokenHash[:models.DETHashValueLength])
-		upper := make([]byte, models.DETHashValueLength, models.IndexTokenHashAndRangeLength)
-		copy(upper, index.TokenHash[:models.DETHashValueLength])
-
-		rangeVal := make([]byte, models.OPERangeValueLength)
-		if rangeOp != objects.RangeBetween && rangeOp != objects.QueryEq {
-			copy(rangeVal, index.TokenRange[:models.OPERangeValueLength])
-		}
+		result.ScannedCount += len(entries)
 
-		switch rangeOp {
-		case objects.QueryEq:
-			keyCondExp = "pk = :pk AND begins_with(sk, :token)"
-			exprAttrValues[":token"] = &ddbTypes.AttributeValueMemberB{Value: index.GetIndexToken()}
-		case objects.RangeGt:
-			keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
-			// lower: hash | rangeVal | max object ID (to exclude value with same rangeVal)
-			// upper: hash | max range value | max object ID
-			lower = append(lower, rangeVal...)
-			lower = append(lower, models.MaxObjectID...)
-			upper = append(upper, models.MaxRangeValue...)
-			upper = append(upper, models.MaxObjectID...)
-			exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
-			exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
-		case objects.RangeGte:
-			keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
-			// lower: hash | rangeVal | min object ID (to include value with same rangeVal)
-			// upper: hash | max range value | max object ID
-			lower = append(lower, rangeVal...)
-			lower = append(lower, models.MinObjectID...)
-			upper = append(upper, models.MaxRangeValue...)
-			upper = append(upper, models.MaxObjectID...)
-			exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
-			exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
-		case objects.RangeLt:
-			keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
-			// lower: hash | min range value | min object ID
-			// upper: hash | rangeVal | min object ID (to exclude value with same rangeVal)
-			lower = append(lower, models.MinRangeValue...)
-			lower = append(lower, models.MinObjectID...)
-			upper = append(upper, rangeVal...)
-			upper = append(upper, models.MinObjectID...)
-			exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
-			exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
-		case objects.RangeLte:
-			keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
-			// lower: hash | min range value | min object ID
-			// upper: hash | rangeVal | max object ID (to include value with same rangeVal)
-			lower = append(lower, models.MinRangeValue...)
-			lower = append(lower, models.MinObjectID...)
-			upper = append(upper, rangeVal...)
-			upper = append(upper, models.MaxObjectID...)
-			exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
-			exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
-		case objects.RangeBetween:
-			keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
-			// lower: hash | betweenRange[0] | min object ID
-			// upper: hash | betweenRange[1] | max object ID
-			lower = append(lower, betweenRange[0][:models.OPERangeValueLength]...)
-			lower = append(lower, models.MinObjectID...)
-			upper = append(upper, betweenRange[1][:models.OPERangeValueLength]...)
-			upper = append(upper, models.MaxObjectID...)
-			exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
-			exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
-		default:
-			return nil, fmt.Errorf("unsupported range operator: %v", rangeOp)
+		orderedIDs := make([]string, 0, len(entries))
+		for _, idx := range entries {
+			orderedIDs = append(orderedIDs, idx.GetObjectID())
 		}
-		out, err = d.Client.Query(ctx, &dynamodb.QueryInput{
-			TableName:                 aws.String(d.IndexesTable),
-			KeyConditionExpression:    aws.String(keyCondExp),
-			ExpressionAttributeValues: exprAttrValues,
-			Limit:                     dynamoLimit,
-			ScanIndexForward:          aws.Bool(scanForward),
-			ExclusiveStartKey: func() map[string]ddbTypes.AttributeValue {
-				if decodedNextToken == nil {
-					return nil
-				}
-				return map[string]ddbTypes.AttributeValue{
-					"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
-					"sk": &ddbTypes.AttributeValueMemberB{Value: decodedNextToken},
-				}
-			}(),
-		})
+		objectsByID, err := d.BatchGetObjects(ctx, tenantId, tableHash, orderedIDs, true, metadataOnly)
 		if err != nil {
 			return nil, err
 		}
-	}
+		for _, id := range orderedIDs {
+			// pending objects are being written with the staged protocol, their indexes may be incomplete
+			if obj, ok := objectsByID[id]; ok && obj.Status == models.StatusReady {
+				result.Objects = append(result.Objects, obj)
+			}
+		}
 
-	orderedIDs := make([]string, 0, len(out.Items))
-	for _, item := range out.Items {
-		var idx models.Index
-		if err := attributevalue.UnmarshalMap(item, &idx); err != nil {
-			return nil, err
+		if cursor == nil || (limit > 0 && len(result.Objects) == limit) || (limit <= 0 && len(result.Objects) > 0) {
+			break
 		}
-		orderedIDs = append(orderedIDs, idx.GetObjectID())
 	}
+	result.Count = len(result.Objects)
+	result.NextCursor = cursor
+	return result, nil
+}
 
+/*
+BatchGetObjects fetches the given objects, keyed by object ID, in batches of 100 run concurrently. Objects that do not exist or have expired are left out.
+Unprocessed keys returned by DynamoDB are retried with exponential backoff until they are read or the context is cancelled.
+If metadataOnly is true, objects are read without their blob and S3 key.
+*/
+func (d *DynamoClient) BatchGetObjects(ctx context.Context, tenantId string, tableHash string, objectIds []string, consistentRead bool, metadataOnly bool) (map[string]models.Object, error) {
 	type batchResult struct {
 		items []map[string]ddbTypes.AttributeValue
 		err   error
 	}
 	batches := make([][]string, 0)
-	for i := 0; i < len(orderedIDs); i += 100 {
-		batches = append(batches, orderedIDs[i:min(i+100, len(orderedIDs))])
+	for i := 0; i < len(objectIds); i += 100 {
+		batches = append(batches, objectIds[i:min(i+100, len(objectIds))])
 	}
 	results := make([]batchResult, len(batches))
 	var wg sync.WaitGroup
@@ -954,8 +1312,13 @@ func (d *DynamoClient) QueryIndexes(ctx context.Context, tenantId string, tableH
 			}
 
 			var allItems []map[string]ddbTypes.AttributeValue
+			keysAndAttributes := ddbTypes.KeysAndAttributes{Keys: keys, ConsistentRead: aws.Bool(consistentRead)}
+			if metadataOnly {
+				keysAndAttributes.ProjectionExpression = aws.String(objectMetadataProjection)
+				keysAndAttributes.ExpressionAttributeNames = map[string]string{"#status": "status"}
+			}
 			remaining := map[string]ddbTypes.KeysAndAttributes{
-				d.ObjectsTable: {Keys: keys, ConsistentRead: aws.Bool(true)},
+				d.ObjectsTable: keysAndAttributes,
 			}
 
 			retryDelay := 50 * time.Millisecond
@@ -986,7 +1349,8 @@ func (d *DynamoClient) QueryIndexes(ctx context.Context, tenantId string, tableH
 	}
 	wg.Wait()
 
-	objectsByID := make(map[string]models.Object, len(orderedIDs))
+	now := time.Now()
+	objectsByID := make(map[string]models.Object, len(objectIds))
 	for _, result := range results {
 		if result.err != nil {
 			return nil, result.err
@@ -996,31 +1360,23 @@ func (d *DynamoClient) QueryIndexes(ctx context.Context, tenantId string, tableH
 			if err := attributevalue.UnmarshalMap(item, &obj); err != nil {
 				return nil, err
 			}
+			if obj.IsExpired(now) {
+				continue
+			}
 			objectsByID[obj.GetObjectID()] = obj
 		}
 	}
+	return objectsByID, nil
+}
 
-	objects := make([]models.Object, 0, len(out.Items))
-	for _, id := range orderedIDs {
-		if obj, ok := objectsByID[id]; ok {
-			objects = append(objects, obj)
-		}
-		if dynamoLimit != nil && len(objects) == int(*dynamoLimit) {
-			break
-		}
-	}
-
-	var newNextToken *string
-	if out.LastEvaluatedKey != nil {
-		if sk, ok := out.LastEvaluatedKey["sk"].(*ddbTypes.AttributeValueMemberB); ok {
-			s := base64.StdEncoding.EncodeToString(sk.Value)
-			newNextToken = &s
-		}
+// objectIndexToken returns the index token with the object ID appended, which keeps tokens unique across objects with the same index values.
+func objectIndexToken(idx objects.Index, objectId string) ([]byte, error) {
+	objIdBytes, err := uuid.Parse(objectId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse object ID as UUID: %v", err)
 	}
-
-	return &QueryResult{
-		Objects:   objects,
-		Count:     int(out.Count),
-		NextToken: newNextToken,
-	}, nil
+	indexToken := idx.GetIndexToken()
+	token := make([]byte, 0, len(indexToken)+len(objIdBytes))
+	token = append(token, indexToken...)
+	return append(token, objIdBytes[:]...), nil
 }
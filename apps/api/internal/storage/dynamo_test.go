@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+const (
+	testTenantID  = "tenant"
+	testTableHash = "table"
+)
+
+func testIndex(t *testing.T, name string, fill byte) objects.Index {
+	t.Helper()
+	return objects.Index{Name: objects.IndexName{HashField: name}, TokenHash: bytes.Repeat([]byte{fill}, models.DETHashValueLength)}
+}
+
+// testCurrentIndexes builds the entries an object has after being written with the given indexes, keyed by name like GetIndexesByObjectID.
+func testCurrentIndexes(t *testing.T, obj *models.Object, indexes []objects.Index, indexShards map[string]int) map[string]models.Index {
+	t.Helper()
+	current := make(map[string]models.Index, len(indexes))
+	for _, idx := range indexes {
+		token, err := objectIndexToken(idx, obj.GetObjectID())
+		if err != nil {
+			t.Fatalf("Failed to build index token: %v", err)
+		}
+		name := idx.GetIndexName()
+		current[name] = *models.NewIndex(name, testTenantID, testTableHash, token, obj.GetObjectID(), obj.Version, obj.S3Key, indexShards[name])
+	}
+	return current
+}
+
+func indexNames(idxs []models.Index) map[string]bool {
+	names := make(map[string]bool, len(idxs))
+	for _, idx := range idxs {
+		names[idx.GetIndexName()] = true
+	}
+	return names
+}
+
+func TestDiffIndexes(t *testing.T) {
+	email, name, city := testIndex(t, "email", 1), testIndex(t, "name", 2), testIndex(t, "city", 3)
+	renamed := testIndex(t, "name", 4)
+
+	tests := []struct {
+		desc        string
+		current     []objects.Index
+		currentKey  string
+		next        []objects.Index
+		nextKey     string
+		shards      map[string]int
+		wantDeletes []string
+		wantPuts    []string
+	}{
+		{desc: "unchanged", current: []objects.Index{email, name}, next: []objects.Index{email, name}},
+		{desc: "new object", next: []objects.Index{email, name}, wantPuts: []string{"email", "name"}},
+		{desc: "token changed", current: []objects.Index{email, name}, next: []objects.Index{email, renamed}, wantDeletes: []string{"name"}, wantPuts: []string{"name"}},
+		{desc: "index removed", current: []objects.Index{email, name}, next: []objects.Index{email}, wantDeletes: []string{"name"}},
+		{desc: "index added", current: []objects.Index{email}, next: []objects.Index{email, city}, wantPuts: []string{"city"}},
+		{desc: "all removed", current: []objects.Index{email, name}, wantDeletes: []string{"email", "name"}},
+		{desc: "s3 key changed", current: []objects.Index{email}, currentKey: "v1", next: []objects.Index{email}, nextKey: "v2", wantPuts: []string{"email"}},
+		{desc: "index sharded", current: []objects.Index{email, name}, next: []objects.Index{email, name}, shards: map[string]int{"email": 4}, wantDeletes: []string{"email"}, wantPuts: []string{"email"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			obj := models.NewObject(testTenantID, testTableHash, uuid.NewString(), nil, nil, nil)
+			obj.S3Key = tt.currentKey
+			current := testCurrentIndexes(t, obj, tt.current, nil)
+			obj.S3Key = tt.nextKey
+			obj.Version++
+
+			deletes, puts, err := diffIndexes(testTenantID, testTableHash, obj, current, tt.next, tt.shards)
+			if err != nil {
+				t.Fatalf("diffIndexes failed: %v", err)
+			}
+			if got := indexNames(deletes); len(got) != len(deletes) || len(got) != len(tt.wantDeletes) {
+				t.Fatalf("Expected deletes %v, got %v", tt.wantDeletes, got)
+			}
+			for _, name := range tt.wantDeletes {
+				if !indexNames(deletes)[name] {
+					t.Errorf("Expected %q to be deleted", name)
+				}
+			}
+			if got := indexNames(puts); len(got) != len(puts) || len(got) != len(tt.wantPuts) {
+				t.Fatalf("Expected puts %v, got %v", tt.wantPuts, got)
+			}
+			for _, put := range puts {
+				if put.S3Key != obj.S3Key || put.ObjectVersion != obj.Version {
+					t.Errorf("Expected %q to be put with S3 key %q at version %d, got %q at %d", put.GetIndexName(), obj.S3Key, obj.Version, put.S3Key, put.ObjectVersion)
+				}
+				if shards := tt.shards[put.GetIndexName()]; shards > 1 {
+					want := models.GenerateIndexShardPK(testTenantID, testTableHash, put.GetIndexName(), models.IndexShardFor(obj.GetObjectID(), shards))
+					if put.PK != want {
+						t.Errorf("Expected %q to be put in partition %q, got %q", put.GetIndexName(), want, put.PK)
+					}
+				}
+			}
+			for _, name := range tt.wantPuts {
+				if !indexNames(puts)[name] {
+					t.Errorf("Expected %q to be put", name)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffIndexesInvalidObjectID(t *testing.T) {
+	obj := models.NewObject(testTenantID, testTableHash, "not-a-uuid", nil, nil, nil)
+	if _, _, err := diffIndexes(testTenantID, testTableHash, obj, nil, []objects.Index{testIndex(t, "email", 1)}, nil); err == nil {
+		t.Fatal("Expected an error for an object ID that is not a UUID")
+	}
+}
@@ -3,9 +3,21 @@ package storage
 import "errors"
 
 var (
-	ErrNotFound          = errors.New("not found")
-	ErrTableNotFound     = errors.New("table not found")
-	ErrNotFoundOrDeleted = errors.New("not found or already deleted")
-	ErrAlreadyExists     = errors.New("already exists")
-	ErrVersionMismatch   = errors.New("version mismatch")
+	ErrNotFound            = errors.New("not found")
+	ErrTableNotFound       = errors.New("table not found")
+	ErrNotFoundOrDeleted   = errors.New("not found or already deleted")
+	ErrAlreadyExists       = errors.New("already exists")
+	ErrVersionMismatch     = errors.New("version mismatch")
+	ErrPending             = errors.New("object has a pending write")
+	ErrInvalidCursor       = errors.New("invalid pagination cursor")
+	ErrTransactionTooLarge = errors.New("transaction exceeds the DynamoDB item limit")
+	ErrVersionNotFound     = errors.New("version not found")
+	ErrNotDeleted          = errors.New("object is not deleted")
+	ErrIndexConflict       = errors.New("index entry held by another object")
+	ErrUploadNotReserved   = errors.New("upload not reserved or reservation expired")
+	ErrUploadNotFound      = errors.New("multipart upload not found")
+	ErrInvalidUploadParts  = errors.New("invalid multipart upload parts")
+	ErrInvalidRange        = errors.New("requested range not satisfiable")
+	ErrChecksumMismatch    = errors.New("content does not match its checksum")
+	ErrIdempotencyKeyLost  = errors.New("idempotency key taken over by another request")
 )
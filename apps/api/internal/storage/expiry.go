@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// notExpiredFilter is the filter expression that skips expired objects, it expects the current Unix time as :now.
+const notExpiredFilter = "(attribute_not_exists(expires_at) OR expires_at > :now)"
+
+// expiryTaskPut returns the transaction item that schedules the expiry of obj's current version, or nil if the object does not expire.
+func (d *DynamoClient) expiryTaskPut(obj *models.Object) (*ddbTypes.TransactWriteItem, error) {
+	if obj.ExpiresAt == 0 {
+		return nil, nil
+	}
+	task := models.NewOutboxTask(uuid.NewString(), models.OutboxTaskExpireObject, obj.GetTenantID(), obj.GetTableHash(), obj.GetObjectID(), obj.Version, time.Unix(obj.ExpiresAt, 0))
+	item, err := d.outboxTaskPut(task)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+/*
+ExpireObject removes an expired object from DynamoDB: the object item, its index entries and its archived versions, like PurgeObject.
+The item is only deleted if it is still at the given version and past its expiry, so the task of a version that was since updated,
+deleted or recovered does nothing; the write that replaced it scheduled its own expiry if it has one.
+An item already reaped by the DynamoDB TTL still has its leftover entries and versions removed.
+Returns false if the object was not removed. The caller is responsible for removing the blobs from S3.
+*/
+func (d *DynamoClient) ExpireObject(ctx context.Context, tenantId string, tableHash string, objectId string, version int32) (bool, error) {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectPK(tenantId, tableHash)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectSK(objectId)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR (#v = :version AND expires_at <= :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#v": "version",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":version": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", version)},
+			":now":     &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := d.deleteIndexesByObject(ctx, tenantId, tableHash, objectId); err != nil {
+		return false, err
+	}
+	if err := d.deleteObjectVersions(ctx, tenantId, tableHash, objectId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+/*
+exportSegmentRange returns the sort key bounds of a segment of a table export.
+All objects of a table share a partition key, so a DynamoDB parallel Scan would read every table of every tenant.
+Instead the object ID space is split on its first two hex digits into totalSegments contiguous ranges, each read with its own Query.
+*/
+func exportSegmentRange(segment int, totalSegments int) (string, string) {
+	lo := segment * 256 / totalSegments
+	hi := (segment+1)*256/totalSegments - 1
+	// object IDs are lowercase UUIDs, '~' sorts after every hex digit and '-'
+	return fmt.Sprintf("%s%02x", models.GenerateObjectSK(""), lo), fmt.Sprintf("%s%02x~", models.GenerateObjectSK(""), hi)
+}
+
+/*
+ScanTableSegment returns a page of ready objects of one segment of a table, in object ID order, starting after cursor.
+A page may be empty while the segment is not exhausted, the returned cursor is nil once it is.
+Returns ErrInvalidCursor if the cursor lies outside the segment.
+*/
+func (d *DynamoClient) ScanTableSegment(ctx context.Context, tenantId string, tableHash string, segment int, totalSegments int, limit int, cursor []byte) (*ScanResult, error) {
+	lower, upper := exportSegmentRange(segment, totalSegments)
+	if cursor != nil && (bytes.Compare(cursor, []byte(lower)) < 0 || bytes.Compare(cursor, []byte(upper)) > 0) {
+		return nil, ErrInvalidCursor
+	}
+
+	pk := models.GenerateObjectPK(tenantId, tableHash)
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.ObjectsTable),
+		KeyConditionExpression: aws.String("pk = :pk AND sk BETWEEN :lower AND :upper"),
+		FilterExpression:       aws.String("#status = :ready AND " + notExpiredFilter),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":pk":    &ddbTypes.AttributeValueMemberS{Value: pk},
+			":lower": &ddbTypes.AttributeValueMemberS{Value: lower},
+			":upper": &ddbTypes.AttributeValueMemberS{Value: upper},
+			":ready": &ddbTypes.AttributeValueMemberS{Value: models.StatusReady},
+			":now":   &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	if cursor != nil {
+		input.ExclusiveStartKey = map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: string(cursor)},
+		}
+	}
+	out, err := d.Client.Query(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ScanResult{
+		Objects:      make([]models.Object, 0, len(out.Items)),
+		ScannedCount: int(out.ScannedCount),
+	}
+	for _, item := range out.Items {
+		var obj models.Object
+		if err := attributevalue.UnmarshalMap(item, &obj); err != nil {
+			return nil, err
+		}
+		result.Objects = append(result.Objects, obj)
+	}
+	result.Count = len(result.Objects)
+	if sk, ok := out.LastEvaluatedKey["sk"].(*ddbTypes.AttributeValueMemberS); ok {
+		result.NextCursor = []byte(sk.Value)
+	}
+	return result, nil
+}
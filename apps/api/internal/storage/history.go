@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// getTableWriteConfig returns the table settings that affect object writes: index shards, history retention and the soft-delete retention override. Tables without a configuration get the defaults.
+func (d *DynamoClient) getTableWriteConfig(ctx context.Context, tenantId string, tableHash string) (*models.TableConfig, error) {
+	out, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.TableConfigTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateTableConfigPK(tenantId, tableHash)},
+		},
+		ProjectionExpression: aws.String("index_shards, history_max_versions, history_max_age_days, soft_delete_retention_days"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tableConfig models.TableConfig
+	if err := attributevalue.UnmarshalMap(out.Item, &tableConfig); err != nil {
+		return nil, err
+	}
+	return &tableConfig, nil
+}
+
+/*
+SetHistoryRetention sets how long past versions of a table's objects are kept: the last maxVersions versions, versions replaced in the last maxAgeDays days, or both.
+Zero disables a limit, and both zero disables history. Versions archived before a change keep the expiry they were written with.
+Returns ErrNotFound if the table has no configuration yet.
+*/
+func (d *DynamoClient) SetHistoryRetention(ctx context.Context, tenantId string, tableHash string, maxVersions int, maxAgeDays int) error {
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.TableConfigTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateTableConfigPK(tenantId, tableHash)},
+		},
+		UpdateExpression:    aws.String("SET history_max_versions = :maxVersions, history_max_age_days = :maxAgeDays, updated_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":maxVersions": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", maxVersions)},
+			":maxAgeDays":  &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", maxAgeDays)},
+			":now":         &ddbTypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrNotFound
+		}
+	}
+	return err
+}
+
+/*
+historyPut returns the transaction item that archives previous, the version being replaced, together with its index entries.
+Returns nil if the table does not keep history.
+*/
+func (d *DynamoClient) historyPut(tableConfig *models.TableConfig, previous *models.Object, indexes map[string]models.Index, now time.Time) (*ddbTypes.TransactWriteItem, error) {
+	if !tableConfig.KeepsHistory() {
+		return nil, nil
+	}
+	var expiresAt time.Time
+	if tableConfig.HistoryMaxAgeDays > 0 {
+		expiresAt = now.Add(time.Duration(tableConfig.HistoryMaxAgeDays) * 24 * time.Hour)
+	}
+	item, err := attributevalue.MarshalMap(models.NewObjectVersion(previous, versionIndexes(indexes), now, expiresAt))
+	if err != nil {
+		return nil, err
+	}
+	return &ddbTypes.TransactWriteItem{
+		Put: &ddbTypes.Put{
+			TableName: aws.String(d.ObjectsTable),
+			Item:      item,
+		},
+	}, nil
+}
+
+/*
+pruneHistory deletes the versions of an object beyond the last maxVersions.
+It runs after the update that archived a version; a failure leaves extra versions behind, which the next update of the object prunes.
+*/
+func (d *DynamoClient) pruneHistory(ctx context.Context, tenantId string, tableHash string, objectId string, maxVersions int) error {
+	if maxVersions <= 0 {
+		return nil
+	}
+	pk := models.GenerateHistoryPK(tenantId, tableHash, objectId)
+	var stale []ddbTypes.WriteRequest
+	kept := 0
+	var startKey map[string]ddbTypes.AttributeValue
+	for {
+		out, err := d.Client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(d.ObjectsTable),
+			KeyConditionExpression: aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			},
+			ProjectionExpression: aws.String("pk, sk"),
+			ScanIndexForward:     aws.Bool(false),
+			ExclusiveStartKey:    startKey,
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			if kept < maxVersions {
+				kept++
+				continue
+			}
+			stale = append(stale, ddbTypes.WriteRequest{
+				DeleteRequest: &ddbTypes.DeleteRequest{Key: item},
+			})
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return d.batchWrite(ctx, d.ObjectsTable, stale)
+}
+
+/*
+ListObjectVersions returns up to limit past versions of an object, newest first, without their blobs.
+Versions past their retention period are skipped. The cursor is the sort key of the last version returned, nil when there are no more versions;
+an empty cursor starts from the newest version.
+*/
+func (d *DynamoClient) ListObjectVersions(ctx context.Context, tenantId string, tableHash string, objectId string, limit int, cursor []byte) ([]models.ObjectVersion, []byte, error) {
+	if limit <= 0 {
+		return nil, nil, fmt.Errorf("invalid limit %d", limit)
+	}
+	pk := models.GenerateHistoryPK(tenantId, tableHash, objectId)
+	var startKey map[string]ddbTypes.AttributeValue
+	if len(cursor) > 0 {
+		if _, err := models.ParseHistorySK(string(cursor)); err != nil {
+			return nil, nil, ErrInvalidCursor
+		}
+		startKey = map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: string(cursor)},
+		}
+	}
+
+	now := time.Now()
+	versions := make([]models.ObjectVersion, 0, limit)
+	for len(versions) < limit {
+		out, err := d.Client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(d.ObjectsTable),
+			KeyConditionExpression: aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			},
+			ProjectionExpression: aws.String("pk, sk, version, sensitivity, s3_key, created_at, updated_at, replaced_at, #ttl"),
+			ExpressionAttributeNames: map[string]string{
+				"#ttl": "ttl",
+			},
+			ScanIndexForward:  aws.Bool(false),
+			Limit:             aws.Int32(int32(limit - len(versions))),
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, item := range out.Items {
+			var version models.ObjectVersion
+			if err := attributevalue.UnmarshalMap(item, &version); err != nil {
+				return nil, nil, err
+			}
+			if !version.IsExpired(now) {
+				versions = append(versions, version)
+			}
+		}
+		startKey = out.LastEvaluatedKey
+		if startKey == nil {
+			return versions, nil, nil
+		}
+	}
+	return versions, []byte(versions[len(versions)-1].SK), nil
+}
+
+// GetObjectVersion returns a past version of an object, or nil if it is not retained.
+func (d *DynamoClient) GetObjectVersion(ctx context.Context, tenantId string, tableHash string, objectId string, version int32, consistentRead bool) (*models.ObjectVersion, error) {
+	out, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateHistoryPK(tenantId, tableHash, objectId)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateHistorySK(version)},
+		},
+		ConsistentRead: aws.Bool(consistentRead),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var objectVersion models.ObjectVersion
+	if err := attributevalue.UnmarshalMap(out.Item, &objectVersion); err != nil {
+		return nil, err
+	}
+	if objectVersion.IsExpired(time.Now()) {
+		return nil, nil
+	}
+	return &objectVersion, nil
+}
+
+/*
+RestoreObjectVersion writes a new version of an object with the content, wrapped DEKs and index entries of a past version.
+The object must be at currentVersion; the version being replaced is archived like on any other update.
+Returns ErrVersionNotFound if the past version is not retained, or the errors of UpdateObjectWithIndexes.
+*/
+func (d *DynamoClient) RestoreObjectVersion(ctx context.Context, tenantId string, tableHash string, objectId string, version int32, currentVersion int32) (*models.Object, error) {
+	past, err := d.GetObjectVersion(ctx, tenantId, tableHash, objectId, version, true)
+	if err != nil {
+		return nil, err
+	}
+	if past == nil {
+		return nil, ErrVersionNotFound
+	}
+
+	indexes := make([]objects.Index, 0, len(past.Indexes))
+	for _, idx := range past.Indexes {
+		indexes = append(indexes, versionIndexToIndex(idx))
+	}
+	now := time.Now().UTC()
+	return d.UpdateObjectWithIndexes(ctx, tenantId, tableHash, objectId, currentVersion, func(obj *models.Object) {
+		obj.EncryptedBlob = past.EncryptedBlob
+		obj.S3Key = past.S3Key
+		obj.ChecksumSHA256 = past.ChecksumSHA256
+		obj.EncryptedMetadata = past.EncryptedMetadata
+		obj.KMSWrappedDEK = past.KMSWrappedDEK
+		obj.MasterWrappedDEK = past.MasterWrappedDEK
+		obj.DEKNonce = past.DEKNonce
+		obj.Sensitivity = past.Sensitivity
+		obj.UpdatedAt = now
+		obj.Version = currentVersion + 1
+	}, indexes)
+}
+
+// versionIndexes snapshots index entries by name, stripping the object ID suffix from their tokens.
+func versionIndexes(indexes map[string]models.Index) []models.VersionIndex {
+	snapshot := make([]models.VersionIndex, 0, len(indexes))
+	for name, idx := range indexes {
+		snapshot = append(snapshot, models.VersionIndex{
+			Name:  name,
+			Token: idx.SK[:len(idx.SK)-models.ObjectIDLength],
+		})
+	}
+	return snapshot
+}
+
+// versionIndexToIndex rebuilds the request form of an archived index entry, splitting the token into its hash and range parts.
+func versionIndexToIndex(idx models.VersionIndex) objects.Index {
+	hashField, rangeField, hasRange := strings.Cut(idx.Name, ":")
+	index := objects.Index{Name: objects.IndexName{HashField: hashField}, TokenHash: idx.Token}
+	if hasRange && len(idx.Token) > models.DETHashValueLength {
+		index.Name.RangeField = &rangeField
+		index.TokenHash = idx.Token[:models.DETHashValueLength]
+		index.TokenRange = idx.Token[models.DETHashValueLength:]
+	}
+	return index
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+/*
+BeginIdempotentRequest reserves an idempotency key for a request, the record expires after ttl and its lock after lockTimeout.
+If the key is free, expired, or held by an abandoned request with the same hash, it is taken over and the lock token of this attempt is returned,
+to be passed to CompleteIdempotentRequest or ReleaseIdempotencyKey. Otherwise the existing record is returned, and the caller decides whether to replay it
+or reject the request.
+*/
+func (d *DynamoClient) BeginIdempotentRequest(ctx context.Context, tenantId string, key string, requestHash []byte, ttl time.Duration, lockTimeout time.Duration) (*models.IdempotencyRecord, string, error) {
+	now := time.Now().UTC()
+	record := models.IdempotencyRecord{
+		PK:          models.GenerateIdempotencyPK(tenantId, key),
+		RequestHash: requestHash,
+		State:       models.IdempotencyInProgress,
+		LockedUntil: now.Add(lockTimeout).Unix(),
+		LockToken:   uuid.NewString(),
+		CreatedAt:   now,
+		TTL:         now.Add(ttl).Unix(),
+	}
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.IdempotencyTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR #ttl < :now OR (#state = :inProgress AND locked_until < :now AND request_hash = :hash)"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl":   "ttl",
+			"#state": "state",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":now":        &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Unix())},
+			":inProgress": &ddbTypes.AttributeValueMemberS{Value: models.IdempotencyInProgress},
+			":hash":       &ddbTypes.AttributeValueMemberB{Value: requestHash},
+		},
+		ReturnValuesOnConditionCheckFailure: ddbTypes.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err == nil {
+		return nil, record.LockToken, nil
+	}
+	var condErr *ddbTypes.ConditionalCheckFailedException
+	if !errors.As(err, &condErr) {
+		return nil, "", err
+	}
+	var existing models.IdempotencyRecord
+	if err := attributevalue.UnmarshalMap(condErr.Item, &existing); err != nil {
+		return nil, "", err
+	}
+	return &existing, "", nil
+}
+
+/*
+CompleteIdempotentRequest stores the response of the request attempt holding the key with lockToken, to be replayed on retries.
+Returns ErrIdempotencyKeyLost if a retry took the key over after the lock timed out; the response is then not stored.
+*/
+func (d *DynamoClient) CompleteIdempotentRequest(ctx context.Context, tenantId string, key string, lockToken string, statusCode int, contentType string, body []byte) error {
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.IdempotencyTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateIdempotencyPK(tenantId, key)},
+		},
+		UpdateExpression:    aws.String("SET #state = :completed, status_code = :status, content_type = :contentType, body = :body REMOVE locked_until, lock_token"),
+		ConditionExpression: aws.String("lock_token = :token AND #state = :inProgress"),
+		ExpressionAttributeNames: map[string]string{
+			"#state": "state",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":completed":   &ddbTypes.AttributeValueMemberS{Value: models.IdempotencyCompleted},
+			":status":      &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", statusCode)},
+			":contentType": &ddbTypes.AttributeValueMemberS{Value: contentType},
+			":body":        &ddbTypes.AttributeValueMemberB{Value: body},
+			":token":       &ddbTypes.AttributeValueMemberS{Value: lockToken},
+			":inProgress":  &ddbTypes.AttributeValueMemberS{Value: models.IdempotencyInProgress},
+		},
+	})
+	return idempotencyLockError(err)
+}
+
+/*
+ReleaseIdempotencyKey removes the record of a key held by the request attempt with lockToken, so that the next request with it is processed again.
+Returns ErrIdempotencyKeyLost if a retry took the key over after the lock timed out; its record is then left to it.
+*/
+func (d *DynamoClient) ReleaseIdempotencyKey(ctx context.Context, tenantId string, key string, lockToken string) error {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.IdempotencyTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateIdempotencyPK(tenantId, key)},
+		},
+		ConditionExpression: aws.String("lock_token = :token AND #state = :inProgress"),
+		ExpressionAttributeNames: map[string]string{
+			"#state": "state",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":token":      &ddbTypes.AttributeValueMemberS{Value: lockToken},
+			":inProgress": &ddbTypes.AttributeValueMemberS{Value: models.IdempotencyInProgress},
+		},
+	})
+	return idempotencyLockError(err)
+}
+
+// idempotencyLockError maps the failed lock condition of a write to an idempotency record to ErrIdempotencyKeyLost.
+func idempotencyLockError(err error) error {
+	var condErr *ddbTypes.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return ErrIdempotencyKeyLost
+	}
+	return err
+}
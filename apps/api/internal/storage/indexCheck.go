@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/api/admin"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+const indexCheckConcurrency = 8
+
+/*
+VerifyIndexes checks the consistency between the objects of a table and their index entries.
+It walks every object item of the table (deleted ones included) and fetches its index entries through gsi1, reporting entries that point at soft-deleted objects
+and entries whose duplicated S3 key no longer matches the object.
+If scanOrphans is true, it then scans the indexes table for every entry of the table, so that entries whose object no longer exists are found even in indexes
+no remaining object uses. The scan reads the whole indexes table, shared by all tenants, so it is only run by the indexcheck command and never on behalf of an API request.
+Index entries that are missing entirely cannot be detected server-side, since only the client can derive index tokens.
+If repair is true, orphaned and dangling entries are deleted and stale S3 keys are rewritten. The objects are listed before the indexes are read,
+so each reported entry is checked again against a consistent read of its object and repaired only if the entry did not change since it was read.
+Entries of objects written in the meantime are left untouched and reported as not repaired.
+*/
+func (d *DynamoClient) VerifyIndexes(ctx context.Context, tenantId string, tableHash string, repair bool, scanOrphans bool) (*admin.VerifyIndexesResponse, error) {
+	report := &admin.VerifyIndexesResponse{
+		TableHash: tableHash,
+		Issues:    []admin.IndexIssue{},
+	}
+
+	objectsByID, err := d.listObjectHeaders(ctx, tenantId, tableHash)
+	if err != nil {
+		return nil, err
+	}
+	report.ObjectsChecked = len(objectsByID)
+
+	// walk gsi1 for every object
+	type objectIndexes struct {
+		obj     models.Object
+		indexes map[string]models.Index
+		err     error
+	}
+	results := make(chan objectIndexes)
+	work := make(chan models.Object)
+	var wg sync.WaitGroup
+	for range indexCheckConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range work {
+				indexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, obj.GetObjectID())
+				results <- objectIndexes{obj: obj, indexes: indexes, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, obj := range objectsByID {
+			work <- obj
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	// entries as read, in the order of report.Issues
+	var issueEntries []models.Index
+	var walkErr error
+	for res := range results {
+		if res.err != nil {
+			if walkErr == nil {
+				walkErr = res.err
+			}
+			continue
+		}
+		for _, idx := range res.indexes {
+			report.IndexesChecked++
+			switch {
+			case res.obj.Status == models.StatusDeleted:
+				report.Issues = append(report.Issues, newIndexIssue(admin.IssueDeletedObject, idx))
+				issueEntries = append(issueEntries, idx)
+			case idx.S3Key != res.obj.S3Key:
+				report.Issues = append(report.Issues, newIndexIssue(admin.IssueStaleS3Key, idx))
+				issueEntries = append(issueEntries, idx)
+			}
+		}
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	// entries whose object item is gone are not reachable through gsi1 from the objects walk
+	if scanOrphans {
+		err = d.scanTableIndexes(ctx, tenantId, tableHash, func(idx models.Index) {
+			if _, ok := objectsByID[idx.GetObjectID()]; ok {
+				return
+			}
+			report.IndexesChecked++
+			report.Issues = append(report.Issues, newIndexIssue(admin.IssueOrphanedIndex, idx))
+			issueEntries = append(issueEntries, idx)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	objectsRead := make(map[string]*models.Object)
+	for i := range report.Issues {
+		idx := issueEntries[i]
+		obj, ok := objectsRead[idx.GetObjectID()]
+		if !ok {
+			obj, err = d.GetObject(ctx, tenantId, tableHash, idx.GetObjectID(), true)
+			if err != nil {
+				return report, err
+			}
+			objectsRead[idx.GetObjectID()] = obj
+		}
+		repaired, err := d.repairIndexEntry(ctx, report.Issues[i].Type, idx, obj)
+		if err != nil {
+			return report, err
+		}
+		if repaired {
+			report.Issues[i].Repaired = true
+			report.Repaired++
+		}
+	}
+
+	return report, nil
+}
+
+// scanTableIndexes calls fn with every index entry of a table, in all its index partitions, by scanning the whole indexes table.
+func (d *DynamoClient) scanTableIndexes(ctx context.Context, tenantId string, tableHash string, fn func(idx models.Index)) error {
+	// the index name follows the prefix, see GenerateIndexPK
+	prefix := models.GenerateIndexPK(tenantId, tableHash, "")
+	var startKey map[string]ddbTypes.AttributeValue
+	for {
+		out, err := d.Client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(d.IndexesTable),
+			FilterExpression: aws.String("begins_with(pk, :prefix)"),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":prefix": &ddbTypes.AttributeValueMemberS{Value: prefix},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			var idx models.Index
+			if err := attributevalue.UnmarshalMap(item, &idx); err != nil {
+				return err
+			}
+			fn(idx)
+		}
+		if out.LastEvaluatedKey == nil {
+			return nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
+
+/*
+repairIndexEntry repairs an index entry reported by VerifyIndexes, given a consistent read of its object (nil if it does not exist).
+An entry is deleted only if its object is still missing or deleted, and its S3 key is rewritten only if its object is still ready with another key.
+Both writes are conditional on the entry being unchanged since it was read, so that entries written concurrently by the object's writers are kept.
+Returns whether the entry was repaired.
+*/
+func (d *DynamoClient) repairIndexEntry(ctx context.Context, issueType string, idx models.Index, obj *models.Object) (bool, error) {
+	key := map[string]ddbTypes.AttributeValue{
+		"pk": &ddbTypes.AttributeValueMemberS{Value: idx.PK},
+		"sk": &ddbTypes.AttributeValueMemberB{Value: idx.SK},
+	}
+	condition, names, values := unchangedIndexCondition(idx)
+
+	var err error
+	switch issueType {
+	case admin.IssueOrphanedIndex, admin.IssueDeletedObject:
+		if obj != nil && obj.Status != models.StatusDeleted {
+			return false, nil
+		}
+		_, err = d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName:                 aws.String(d.IndexesTable),
+			Key:                       key,
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+	case admin.IssueStaleS3Key:
+		if obj == nil || obj.Status != models.StatusReady || obj.S3Key == idx.S3Key {
+			return false, nil
+		}
+		input := &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(d.IndexesTable),
+			Key:                       key,
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}
+		if obj.S3Key == "" {
+			input.UpdateExpression = aws.String("REMOVE s3_key")
+		} else {
+			input.UpdateExpression = aws.String("SET s3_key = :newS3Key")
+			if input.ExpressionAttributeValues == nil {
+				input.ExpressionAttributeValues = map[string]ddbTypes.AttributeValue{}
+			}
+			input.ExpressionAttributeValues[":newS3Key"] = &ddbTypes.AttributeValueMemberS{Value: obj.S3Key}
+		}
+		_, err = d.Client.UpdateItem(ctx, input)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			// the entry was rewritten or removed in the meantime
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// unchangedIndexCondition returns a condition expression that holds if an index entry still has the object version and S3 key it was read with.
+func unchangedIndexCondition(idx models.Index) (string, map[string]string, map[string]ddbTypes.AttributeValue) {
+	names := map[string]string{"#v": "object_version"}
+	values := map[string]ddbTypes.AttributeValue{}
+	condition := "attribute_exists(pk)"
+	if idx.ObjectVersion == 0 {
+		condition += " AND attribute_not_exists(#v)"
+	} else {
+		condition += " AND #v = :v"
+		values[":v"] = &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", idx.ObjectVersion)}
+	}
+	if idx.S3Key == "" {
+		condition += " AND attribute_not_exists(s3_key)"
+	} else {
+		condition += " AND s3_key = :s3Key"
+		values[":s3Key"] = &ddbTypes.AttributeValueMemberS{Value: idx.S3Key}
+	}
+	if len(values) == 0 {
+		// DynamoDB rejects an empty map
+		values = nil
+	}
+	return condition, names, values
+}
+
+// listObjectHeaders returns the key, status, version and S3 key of every object item of a table, keyed by object ID.
+func (d *DynamoClient) listObjectHeaders(ctx context.Context, tenantId string, tableHash string) (map[string]models.Object, error) {
+	pk := models.GenerateObjectPK(tenantId, tableHash)
+	objectsByID := make(map[string]models.Object)
+	var startKey map[string]ddbTypes.AttributeValue
+	for {
+		out, err := d.Client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(d.ObjectsTable),
+			KeyConditionExpression: aws.String("pk = :pk"),
+			ProjectionExpression:   aws.String("pk, sk, #status, #v, s3_key"),
+			ExpressionAttributeNames: map[string]string{
+				"#status": "status",
+				"#v":      "version",
+			},
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			var obj models.Object
+			if err := attributevalue.UnmarshalMap(item, &obj); err != nil {
+				return nil, err
+			}
+			objectsByID[obj.GetObjectID()] = obj
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return objectsByID, nil
+}
+
+func newIndexIssue(issueType string, idx models.Index) admin.IndexIssue {
+	return admin.IndexIssue{
+		Type:      issueType,
+		ObjectID:  idx.GetObjectID(),
+		IndexName: idx.GetIndexName(),
+		PK:        idx.PK,
+		SK:        idx.SK,
+	}
+}
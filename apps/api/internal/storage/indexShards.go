@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// GetIndexShards returns the number of write shards of every sharded index of a table, keyed by index name.
+func (d *DynamoClient) GetIndexShards(ctx context.Context, tenantId string, tableHash string) (map[string]int, error) {
+	out, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.TableConfigTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateTableConfigPK(tenantId, tableHash)},
+		},
+		ProjectionExpression: aws.String("index_shards"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tableConfig models.TableConfig
+	if err := attributevalue.UnmarshalMap(out.Item, &tableConfig); err != nil {
+		return nil, err
+	}
+	return tableConfig.IndexShards, nil
+}
+
+/*
+SetIndexShards enables write sharding for an index of a table.
+The shard count of an index cannot be changed once set, since entries are placed by hashing the object ID modulo the shard count.
+Returns ErrNotFound if the table has no configuration yet, or ErrAlreadyExists if the index is already sharded.
+*/
+func (d *DynamoClient) SetIndexShards(ctx context.Context, tenantId string, tableHash string, indexName string, shards int) error {
+	key := map[string]ddbTypes.AttributeValue{
+		"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateTableConfigPK(tenantId, tableHash)},
+	}
+
+	// a nested attribute can only be set once its parent map exists
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.TableConfigTable),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET index_shards = if_not_exists(index_shards, :empty)"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":empty": &ddbTypes.AttributeValueMemberM{Value: map[string]ddbTypes.AttributeValue{}},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	_, err = d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.TableConfigTable),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET index_shards.#name = :shards, updated_at = :now"),
+		ConditionExpression: aws.String("attribute_not_exists(index_shards.#name)"),
+		ExpressionAttributeNames: map[string]string{
+			"#name": indexName,
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":shards": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", shards)},
+			":now":    &ddbTypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrAlreadyExists
+		}
+	}
+	return err
+}
+
+// shardedIndexCursor is the pagination state of a query over a sharded index.
+type shardedIndexCursor struct {
+	// last sort key returned from every partition that is not exhausted yet, keyed by position in models.GenerateIndexPartitionPKs; nil if the partition has not been read yet
+	Partitions map[int][]byte `json:"partitions"`
+}
+
+/*
+queryShardedIndex runs the same key condition on every partition of a sharded index and merges the results in sort key order.
+Each partition is queried concurrently with the full limit. When a partition has more entries than it returned, merged entries past its last evaluated key are held back,
+since entries of that partition not fetched yet could sort before them. The returned cursor holds the position of every partition not exhausted yet.
+*/
+func (d *DynamoClient) queryShardedIndex(ctx context.Context, pks []string, keyCond string, values map[string]ddbTypes.AttributeValue, limit int, startCursor []byte, tokenLength int, scanForward bool, consistentRead bool) ([]models.Index, []byte, error) {
+	cursor := shardedIndexCursor{Partitions: make(map[int][]byte, len(pks))}
+	if startCursor == nil {
+		for i := range pks {
+			cursor.Partitions[i] = nil
+		}
+	} else {
+		if err := json.Unmarshal(startCursor, &cursor); err != nil {
+			return nil, nil, ErrInvalidCursor
+		}
+		for partition, sk := range cursor.Partitions {
+			if partition < 0 || partition >= len(pks) || (sk != nil && len(sk) != tokenLength) {
+				return nil, nil, ErrInvalidCursor
+			}
+		}
+	}
+
+	type partitionPage struct {
+		partition int
+		items     []models.Index
+		lastKey   []byte // last evaluated sort key, nil if the partition is exhausted
+		err       error
+	}
+	partitions := slices.Sorted(maps.Keys(cursor.Partitions))
+	pages := make([]partitionPage, len(partitions))
+	var wg sync.WaitGroup
+	for i, partition := range partitions {
+		wg.Add(1)
+		go func(i int, partition int) {
+			defer wg.Done()
+			out, err := d.queryIndexPartition(ctx, pks[partition], keyCond, values, limit, cursor.Partitions[partition], scanForward, consistentRead)
+			if err != nil {
+				pages[i] = partitionPage{partition: partition, err: err}
+				return
+			}
+			page := partitionPage{partition: partition, items: make([]models.Index, 0, len(out.Items))}
+			for _, item := range out.Items {
+				var idx models.Index
+				if err := attributevalue.UnmarshalMap(item, &idx); err != nil {
+					pages[i] = partitionPage{partition: partition, err: err}
+					return
+				}
+				page.items = append(page.items, idx)
+			}
+			if sk, ok := out.LastEvaluatedKey["sk"].(*ddbTypes.AttributeValueMemberB); ok {
+				page.lastKey = sk.Value
+			}
+			pages[i] = page
+		}(i, partition)
+	}
+	wg.Wait()
+
+	// before reports whether sort key a comes before b in the query direction
+	before := func(a, b []byte) bool {
+		if scanForward {
+			return bytes.Compare(a, b) < 0
+		}
+		return bytes.Compare(a, b) > 0
+	}
+
+	type partitionEntry struct {
+		partition int
+		idx       models.Index
+	}
+	var merged []partitionEntry
+	var cutoff []byte
+	for _, page := range pages {
+		if page.err != nil {
+			return nil, nil, page.err
+		}
+		for _, idx := range page.items {
+			merged = append(merged, partitionEntry{partition: page.partition, idx: idx})
+		}
+		if page.lastKey != nil && (cutoff == nil || before(page.lastKey, cutoff)) {
+			cutoff = page.lastKey
+		}
+	}
+	slices.SortStableFunc(merged, func(a, b partitionEntry) int {
+		if scanForward {
+			return bytes.Compare(a.idx.SK, b.idx.SK)
+		}
+		return bytes.Compare(b.idx.SK, a.idx.SK)
+	})
+
+	entries := make([]models.Index, 0, len(merged))
+	consumed := make(map[int]int, len(pages))
+	for _, e := range merged {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		if cutoff != nil && before(cutoff, e.idx.SK) {
+			break
+		}
+		entries = append(entries, e.idx)
+		cursor.Partitions[e.partition] = e.idx.SK
+		consumed[e.partition]++
+	}
+	for _, page := range pages {
+		if page.lastKey == nil && consumed[page.partition] == len(page.items) {
+			delete(cursor.Partitions, page.partition)
+		} else if consumed[page.partition] == 0 && len(page.items) == 0 {
+			cursor.Partitions[page.partition] = page.lastKey
+		}
+	}
+
+	if len(cursor.Partitions) == 0 {
+		return entries, nil, nil
+	}
+	nextCursor, err := json.Marshal(cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries, nextCursor, nil
+}
+
+// queryIndexPartition runs a key condition built by indexKeyCondition on a single index partition.
+func (d *DynamoClient) queryIndexPartition(ctx context.Context, pk string, keyCond string, values map[string]ddbTypes.AttributeValue, limit int, startSK []byte, scanForward bool, consistentRead bool) (*dynamodb.QueryOutput, error) {
+	exprValues := maps.Clone(values)
+	exprValues[":pk"] = &ddbTypes.AttributeValueMemberS{Value: pk}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(d.IndexesTable),
+		KeyConditionExpression:    aws.String(keyCond),
+		ExpressionAttributeValues: exprValues,
+		ScanIndexForward:          aws.Bool(scanForward),
+		ConsistentRead:            aws.Bool(consistentRead),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	if startSK != nil {
+		input.ExclusiveStartKey = map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			"sk": &ddbTypes.AttributeValueMemberB{Value: startSK},
+		}
+	}
+	return d.Client.Query(ctx, input)
+}
+
+/*
+indexKeyCondition translates an index token and range operator into a DynamoDB key condition.
+The returned expression references the partition key as :pk, which is bound by queryIndexPartition for every partition queried.
+*/
+func indexKeyCondition(index objects.Index, betweenRange [2][]byte, rangeOp objects.QueryOperator) (string, map[string]ddbTypes.AttributeValue, error) {
+	if index.Name.RangeField == nil {
+		if !index.IsHashOnly() || betweenRange[0] != nil || betweenRange[1] != nil {
+			return "", nil, fmt.Errorf("invalid index: for hash-only index, token must be non-nil and betweenRange must be nil")
+		}
+		// hash-only index, query by pk + token prefix
+		return "pk = :pk AND begins_with(sk, :prefix)", map[string]ddbTypes.AttributeValue{
+			":prefix": &ddbTypes.AttributeValueMemberB{Value: index.TokenHash},
+		}, nil
+	}
+
+	// hash+range index, handle different range queries by translating to DynamoDB syntax
+	if rangeOp == objects.RangeBetween {
+		if !index.IsHashOnly() {
+			return "", nil, fmt.Errorf("invalid index: for RangeBetween operator, index must be hash-only and betweenRange must be provided")
+		}
+		if len(betweenRange[0]) != models.OPERangeValueLength || len(betweenRange[1]) != models.OPERangeValueLength {
+			return "", nil, fmt.Errorf("invalid betweenRange token length for range index: expected %d, got %d and %d", models.OPERangeValueLength, len(betweenRange[0]), len(betweenRange[1]))
+		}
+	}
+
+	var keyCondExp string
+	exprAttrValues := map[string]ddbTypes.AttributeValue{}
+	lower := make([]byte, models.DETHashValueLength, models.IndexTokenHashAndRangeLength)
+	copy(lower, index.TokenHash[:models.DETHashValueLength])
+	upper := make([]byte, models.DETHashValueLength, models.IndexTokenHashAndRangeLength)
+	copy(upper, index.TokenHash[:models.DETHashValueLength])
+
+	rangeVal := make([]byte, models.OPERangeValueLength)
+	if rangeOp != objects.RangeBetween && rangeOp != objects.QueryEq {
+		copy(rangeVal, index.TokenRange[:models.OPERangeValueLength])
+	}
+
+	switch rangeOp {
+	case objects.QueryEq:
+		keyCondExp = "pk = :pk AND begins_with(sk, :token)"
+		exprAttrValues[":token"] = &ddbTypes.AttributeValueMemberB{Value: index.GetIndexToken()}
+	case objects.RangeGt:
+		keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
+		// lower: hash | rangeVal | max object ID (to exclude value with same rangeVal)
+		// upper: hash | max range value | max object ID
+		lower = append(lower, rangeVal...)
+		lower = append(lower, models.MaxObjectID...)
+		upper = append(upper, models.MaxRangeValue...)
+		upper = append(upper, models.MaxObjectID...)
+		exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
+		exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
+	case objects.RangeGte:
+		keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
+		// lower: hash | rangeVal | min object ID (to include value with same rangeVal)
+		// upper: hash | max range value | max object ID
+		lower = append(lower, rangeVal...)
+		lower = append(lower, models.MinObjectID...)
+		upper = append(upper, models.MaxRangeValue...)
+		upper = append(upper, models.MaxObjectID...)
+		exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
+		exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
+	case objects.RangeLt:
+		keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
+		// lower: hash | min range value | min object ID
+		// upper: hash | rangeVal | min object ID (to exclude value with same rangeVal)
+		lower = append(lower, models.MinRangeValue...)
+		lower = append(lower, models.MinObjectID...)
+		upper = append(upper, rangeVal...)
+		upper = append(upper, models.MinObjectID...)
+		exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
+		exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
+	case objects.RangeLte:
+		keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
+		// lower: hash | min range value | min object ID
+		// upper: hash | rangeVal | max object ID (to include value with same rangeVal)
+		lower = append(lower, models.MinRangeValue...)
+		lower = append(lower, models.MinObjectID...)
+		upper = append(upper, rangeVal...)
+		upper = append(upper, models.MaxObjectID...)
+		exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
+		exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
+	case objects.RangeBetween:
+		keyCondExp = "pk = :pk AND sk BETWEEN :lower AND :upper"
+		// lower: hash | betweenRange[0] | min object ID
+		// upper: hash | betweenRange[1] | max object ID
+		lower = append(lower, betweenRange[0][:models.OPERangeValueLength]...)
+		lower = append(lower, models.MinObjectID...)
+		upper = append(upper, betweenRange[1][:models.OPERangeValueLength]...)
+		upper = append(upper, models.MaxObjectID...)
+		exprAttrValues[":lower"] = &ddbTypes.AttributeValueMemberB{Value: lower}
+		exprAttrValues[":upper"] = &ddbTypes.AttributeValueMemberB{Value: upper}
+	default:
+		return "", nil, fmt.Errorf("unsupported range operator: %v", rangeOp)
+	}
+	return keyCondExp, exprAttrValues, nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// CreateMultipartUpload starts a multipart upload of a blob and returns its upload ID.
+func (s *S3Client) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+// PresignUploadPartUrls generates a presigned URL for each of the given parts of a reserved multipart upload, signed for the exact size of the part.
+func (s *S3Client) PresignUploadPartUrls(ctx context.Context, reservation *models.UploadReservation, partNumbers []int32, lifetime time.Duration) ([]objects.UploadPart, error) {
+	parts := make([]objects.UploadPart, 0, len(partNumbers))
+	for _, partNumber := range partNumbers {
+		request, err := s.presigner.PresignUploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.Bucket),
+			Key:           aws.String(reservation.S3Key),
+			UploadId:      aws.String(reservation.UploadID),
+			PartNumber:    aws.Int32(partNumber),
+			ContentLength: aws.Int64(reservation.PartLength(partNumber)),
+		}, func(opts *s3.PresignOptions) {
+			opts.Expires = lifetime
+		})
+		if err != nil {
+			return nil, err
+		}
+		if request.URL == "" {
+			return nil, errors.New("failed to generate presigned URL")
+		}
+		parts = append(parts, objects.UploadPart{PartNumber: partNumber, URL: request.URL})
+	}
+	return parts, nil
+}
+
+// ListUploadedParts returns the parts S3 received for a multipart upload, by part number. Returns ErrUploadNotFound if the upload was completed or aborted.
+func (s *S3Client) ListUploadedParts(ctx context.Context, key string, uploadId string) ([]objects.UploadedPart, error) {
+	var parts []objects.UploadedPart
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			var noSuchUpload *s3Types.NoSuchUpload
+			if errors.As(err, &noSuchUpload) {
+				return nil, ErrUploadNotFound
+			}
+			return nil, err
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, objects.UploadedPart{
+				PartNumber: aws.ToInt32(part.PartNumber),
+				ETag:       aws.ToString(part.ETag),
+				Size:       aws.ToInt64(part.Size),
+			})
+		}
+	}
+	return parts, nil
+}
+
+/*
+CompleteMultipartUpload assembles the uploaded parts of a multipart upload into the blob. If parts is empty, every part S3 received is used.
+Returns ErrUploadNotFound if the upload was already completed or aborted, and ErrInvalidUploadParts if a part is missing, does not match
+its ETag or, except for the last one, is smaller than the S3 minimum.
+*/
+func (s *S3Client) CompleteMultipartUpload(ctx context.Context, key string, uploadId string, parts []objects.CompletedPart) error {
+	if len(parts) == 0 {
+		uploaded, err := s.ListUploadedParts(ctx, key, uploadId)
+		if err != nil {
+			return err
+		}
+		for _, part := range uploaded {
+			parts = append(parts, objects.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if len(parts) == 0 {
+			return ErrInvalidUploadParts
+		}
+	}
+	// S3 expects the parts in ascending order
+	completed := make([]s3Types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, s3Types.CompletedPart{PartNumber: aws.Int32(part.PartNumber), ETag: aws.String(part.ETag)})
+	}
+	slices.SortFunc(completed, func(a, b s3Types.CompletedPart) int {
+		return int(aws.ToInt32(a.PartNumber) - aws.ToInt32(b.PartNumber))
+	})
+
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &s3Types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		var noSuchUpload *s3Types.NoSuchUpload
+		if errors.As(err, &noSuchUpload) {
+			return ErrUploadNotFound
+		}
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "InvalidPart", "InvalidPartOrder", "EntityTooSmall":
+				return ErrInvalidUploadParts
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts. Returns the total size of the discarded parts, an upload already completed or aborted is not an error.
+func (s *S3Client) AbortMultipartUpload(ctx context.Context, key string, uploadId string) (int64, error) {
+	parts, err := s.ListUploadedParts(ctx, key, uploadId)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var size int64
+	for _, part := range parts {
+		size += part.Size
+	}
+
+	_, err = s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	if err != nil {
+		var noSuchUpload *s3Types.NoSuchUpload
+		if errors.As(err, &noSuchUpload) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+/*
+DiscardUpload deletes whatever was uploaded for a large object upload that will not be confirmed: the parts of its multipart upload, if uploadId is set,
+and every version of its blob. Returns whether anything had been uploaded and its total size in bytes.
+*/
+func (s *S3Client) DiscardUpload(ctx context.Context, key string, uploadId string) (bool, int64, error) {
+	var partsSize int64
+	if uploadId != "" {
+		size, err := s.AbortMultipartUpload(ctx, key, uploadId)
+		if err != nil {
+			return false, 0, err
+		}
+		partsSize = size
+	}
+	versions, size, err := s.PurgeKey(ctx, key)
+	if err != nil {
+		return false, 0, err
+	}
+	return versions > 0 || partsSize > 0, partsSize + size, nil
+}
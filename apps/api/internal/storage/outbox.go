@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// outboxTaskPut returns the transaction item that enqueues the given task, to be written together with the change that requires it.
+func (d *DynamoClient) outboxTaskPut(task *models.OutboxTask) (ddbTypes.TransactWriteItem, error) {
+	item, err := attributevalue.MarshalMap(task)
+	if err != nil {
+		return ddbTypes.TransactWriteItem{}, err
+	}
+	return ddbTypes.TransactWriteItem{
+		Put: &ddbTypes.Put{
+			TableName: aws.String(d.OutboxTable),
+			Item:      item,
+		},
+	}, nil
+}
+
+/*
+ListDueOutboxTasks returns up to limit tasks of an outbox shard that are due at the given time and not leased by a worker, oldest due first.
+Tasks are read from gsi1, keyed by shard and next attempt time, so tasks scheduled for later, e.g. months ahead for expiries, are never read;
+only the due tasks currently leased are filtered out. The index is eventually consistent, ClaimOutboxTask checks the task again.
+*/
+func (d *DynamoClient) ListDueOutboxTasks(ctx context.Context, shard int, now time.Time, limit int) ([]models.OutboxTask, error) {
+	tasks := make([]models.OutboxTask, 0, limit)
+	var startKey map[string]ddbTypes.AttributeValue
+	for len(tasks) < limit {
+		out, err := d.Client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(d.OutboxTable),
+			IndexName:              aws.String("gsi1"),
+			KeyConditionExpression: aws.String("pk = :pk AND next_attempt_at <= :now"),
+			FilterExpression:       aws.String("attribute_not_exists(lease_until) OR lease_until < :now"),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":pk":  &ddbTypes.AttributeValueMemberS{Value: models.GenerateOutboxPK(shard)},
+				":now": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Unix())},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			var task models.OutboxTask
+			if err := attributevalue.UnmarshalMap(item, &task); err != nil {
+				return nil, err
+			}
+			tasks = append(tasks, task)
+			if len(tasks) == limit {
+				break
+			}
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return tasks, nil
+}
+
+/*
+ClaimOutboxTask leases a task to the calling worker until the given time, so that concurrent API server instances do not process it twice.
+Returns false if the task was completed, rescheduled or leased by another worker in the meantime.
+*/
+func (d *DynamoClient) ClaimOutboxTask(ctx context.Context, task *models.OutboxTask, leaseUntil time.Time) (bool, error) {
+	now := time.Now().Unix()
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.OutboxTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: task.PK},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: task.SK},
+		},
+		UpdateExpression:    aws.String("SET lease_until = :leaseUntil"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND next_attempt_at <= :now AND (attribute_not_exists(lease_until) OR lease_until < :now)"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":leaseUntil": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", leaseUntil.Unix())},
+			":now":        &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return false, nil
+		}
+		return false, err
+	}
+	task.LeaseUntil = leaseUntil.Unix()
+	return true, nil
+}
+
+// CompleteOutboxTask removes a processed task from the outbox.
+func (d *DynamoClient) CompleteOutboxTask(ctx context.Context, task *models.OutboxTask) error {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.OutboxTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: task.PK},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: task.SK},
+		},
+	})
+	return err
+}
+
+// RescheduleOutboxTask records a failed attempt and releases the lease, so the task is picked up again at nextAttemptAt.
+func (d *DynamoClient) RescheduleOutboxTask(ctx context.Context, task *models.OutboxTask, nextAttemptAt time.Time, lastError string) error {
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.OutboxTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: task.PK},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: task.SK},
+		},
+		UpdateExpression:    aws.String("SET attempts = attempts + :inc, next_attempt_at = :next, last_error = :lastError REMOVE lease_until"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":inc":       &ddbTypes.AttributeValueMemberN{Value: "1"},
+			":next":      &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", nextAttemptAt.Unix())},
+			":lastError": &ddbTypes.AttributeValueMemberS{Value: lastError},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return nil
+		}
+	}
+	return err
+}
+
+/*
+DeleteIndexesOfDeletedObject removes the index entries of a soft-deleted object, deletedVersion being the version the delete produced.
+The object is read with a strongly consistent read first: if it has been recovered in the meantime, its index entries are left untouched and the call succeeds.
+Each entry is deleted on condition that it was written before the delete, so entries reinstated by a recover racing with the cleanup are kept.
+*/
+func (d *DynamoClient) DeleteIndexesOfDeletedObject(ctx context.Context, tenantId string, tableHash string, objectId string, deletedVersion int32) error {
+	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId, true)
+	if err != nil {
+		return err
+	}
+	if obj != nil && obj.Status != models.StatusDeleted {
+		return nil
+	}
+	indexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, objectId)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if idx.ObjectVersion > deletedVersion {
+			continue
+		}
+		_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(d.IndexesTable),
+			Key: map[string]ddbTypes.AttributeValue{
+				"pk": &ddbTypes.AttributeValueMemberS{Value: idx.PK},
+				"sk": &ddbTypes.AttributeValueMemberB{Value: idx.SK},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(object_version) OR object_version <= :deleted"),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":deleted": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", deletedVersion)},
+			},
+		})
+		if err != nil {
+			var condErr *ddbTypes.ConditionalCheckFailedException
+			if errors.As(err, &condErr) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/api/admin"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// reconcileMinBlobAge is how old a blob must be before it is reconciled, so that writes still in flight are not mistaken for garbage.
+const reconcileMinBlobAge = 24 * time.Hour
+
+type unreferencedCandidate struct {
+	key          string
+	size         int64
+	lastModified time.Time
+}
+
+/*
+ReconcileBlobs finds the blobs of a table that no object references anymore, e.g. the previous blob of an object updated in place
+or switched to an inline blob, and those of versions dropped from the history.
+It lists the blobs under the table prefix and compares them with the current blob of every object item (deleted ones included, their blobs
+are purged by the outbox once they leave the trash), the blobs of the retained past versions and those of pending uploads.
+Each candidate is checked again against a consistent read of its object before being reported, and blobs younger than reconcileMinBlobAge are skipped.
+In tag mode unreferenced blobs are tagged for deletion with the table's soft-delete retention, in delete mode they are purged with all their versions.
+*/
+func ReconcileBlobs(ctx context.Context, d *DynamoClient, s *S3Client, tenantId string, tableHash string, mode string) (*admin.ReconcileBlobsResponse, error) {
+	retentionDays, err := d.SoftDeleteRetentionDays(ctx, tenantId, tableHash)
+	if err != nil {
+		return nil, err
+	}
+	report := &admin.ReconcileBlobsResponse{
+		TableHash:    tableHash,
+		Mode:         mode,
+		Unreferenced: []admin.UnreferencedBlob{},
+	}
+
+	objectsByID, err := d.listObjectHeaders(ctx, tenantId, tableHash)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool, len(objectsByID))
+	for _, obj := range objectsByID {
+		if obj.S3Key != "" {
+			referenced[obj.S3Key] = true
+		}
+	}
+
+	prefix := tableS3Prefix(tenantId, tableHash)
+	cutoff := time.Now().Add(-reconcileMinBlobAge)
+	candidates := make(map[string][]unreferencedCandidate)
+	err = s.ListKeys(ctx, prefix, func(key string, size int64, lastModified time.Time) error {
+		report.BlobsScanned++
+		if referenced[key] {
+			return nil
+		}
+		if lastModified.After(cutoff) {
+			report.BlobsSkipped++
+			return nil
+		}
+		objectId, _, _ := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		candidates[objectId] = append(candidates[objectId], unreferencedCandidate{key: key, size: size, lastModified: lastModified})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	objectIds := make([]string, 0, len(candidates))
+	for objectId := range candidates {
+		objectIds = append(objectIds, objectId)
+	}
+	slices.Sort(objectIds)
+	for _, objectId := range objectIds {
+		refs, err := d.blobReferences(ctx, tenantId, tableHash, objectId)
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range candidates[objectId] {
+			if refs[blob.key] {
+				continue
+			}
+			switch mode {
+			case admin.ReconcileModeTag:
+				if err := s.TagForDeletion(ctx, blob.key, retentionDays); err != nil {
+					return nil, err
+				}
+				report.Tagged++
+			case admin.ReconcileModeDelete:
+				if _, _, err := s.PurgeKey(ctx, blob.key); err != nil {
+					return nil, err
+				}
+				report.Deleted++
+			}
+			report.UnreferencedCount++
+			report.UnreferencedBytes += blob.size
+			if len(report.Unreferenced) < admin.MaxReportedBlobs {
+				report.Unreferenced = append(report.Unreferenced, admin.UnreferencedBlob{
+					Key:          blob.key,
+					ObjectID:     objectId,
+					Size:         blob.size,
+					LastModified: blob.lastModified,
+				})
+			} else {
+				report.Truncated = true
+			}
+		}
+	}
+	return report, nil
+}
+
+// blobReferences returns the S3 keys an object references, read consistently: its current blob, those of its retained past versions and of its pending uploads.
+func (d *DynamoClient) blobReferences(ctx context.Context, tenantId string, tableHash string, objectId string) (map[string]bool, error) {
+	refs := make(map[string]bool)
+	out, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectPK(tenantId, tableHash)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectSK(objectId)},
+		},
+		ProjectionExpression: aws.String("s3_key"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item != nil {
+		var obj models.Object
+		if err := attributevalue.UnmarshalMap(out.Item, &obj); err != nil {
+			return nil, err
+		}
+		if obj.S3Key != "" {
+			refs[obj.S3Key] = true
+		}
+	}
+
+	now := time.Now()
+	err = d.queryBlobKeys(ctx, models.GenerateHistoryPK(tenantId, tableHash, objectId), func(item map[string]ddbTypes.AttributeValue) error {
+		var version models.ObjectVersion
+		if err := attributevalue.UnmarshalMap(item, &version); err != nil {
+			return err
+		}
+		// versions past their retention are no longer restorable, even if the TTL did not reap them yet
+		if version.S3Key != "" && !version.IsExpired(now) {
+			refs[version.S3Key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// expired reservations are left to the sweeper
+	err = d.queryBlobKeys(ctx, models.GenerateUploadPK(tenantId, tableHash, objectId), func(item map[string]ddbTypes.AttributeValue) error {
+		var reservation models.UploadReservation
+		if err := attributevalue.UnmarshalMap(item, &reservation); err != nil {
+			return err
+		}
+		refs[reservation.S3Key] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// queryBlobKeys calls fn with the s3_key and ttl of every item of a partition, read consistently.
+func (d *DynamoClient) queryBlobKeys(ctx context.Context, pk string, fn func(item map[string]ddbTypes.AttributeValue) error) error {
+	var startKey map[string]ddbTypes.AttributeValue
+	for {
+		out, err := d.Client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(d.ObjectsTable),
+			KeyConditionExpression: aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			},
+			ProjectionExpression: aws.String("s3_key, #ttl"),
+			ExpressionAttributeNames: map[string]string{
+				"#ttl": "ttl",
+			},
+			ConsistentRead:    aws.Bool(true),
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if out.LastEvaluatedKey == nil {
+			return nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// SoftDeleteRetentionDays returns how many days soft-deleted objects of a table stay recoverable: the table override, else the tenant setting, else the default.
+func (d *DynamoClient) SoftDeleteRetentionDays(ctx context.Context, tenantId string, tableHash string) (int, error) {
+	tableConfig, err := d.getTableWriteConfig(ctx, tenantId, tableHash)
+	if err != nil {
+		return 0, err
+	}
+	if tableConfig.SoftDeleteRetentionDays != nil {
+		return *tableConfig.SoftDeleteRetentionDays, nil
+	}
+	tenant, err := d.GetTenant(ctx, tenantId)
+	if err != nil {
+		return 0, err
+	}
+	return models.SoftDeleteRetentionDays(tenant, tableConfig), nil
+}
+
+/*
+SetSoftDeleteRetention sets the soft-delete retention of a tenant, or overrides it for a single table if tableHash is not empty.
+A nil days removes the setting: the table inherits the tenant's retention again, or the tenant falls back to the default.
+Objects already in the trash keep the expiry they were deleted with. Returns ErrNotFound if the tenant or table has no configuration.
+*/
+func (d *DynamoClient) SetSoftDeleteRetention(ctx context.Context, tenantId string, tableHash string, days *int) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.TenantConfigTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateTenantConfigPK(tenantId)},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":now": &ddbTypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	}
+	if tableHash != "" {
+		input.TableName = aws.String(d.TableConfigTable)
+		input.Key["pk"] = &ddbTypes.AttributeValueMemberS{Value: models.GenerateTableConfigPK(tenantId, tableHash)}
+	}
+	if days != nil {
+		input.UpdateExpression = aws.String("SET soft_delete_retention_days = :days, updated_at = :now")
+		input.ExpressionAttributeValues[":days"] = &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", *days)}
+	} else {
+		input.UpdateExpression = aws.String("SET updated_at = :now REMOVE soft_delete_retention_days")
+	}
+
+	_, err := d.Client.UpdateItem(ctx, input)
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrNotFound
+		}
+	}
+	return err
+}
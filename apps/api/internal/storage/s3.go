@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -16,6 +18,16 @@ type S3Client struct {
 	presigner *s3.PresignClient
 }
 
+// ObjectS3Prefix returns the key prefix under which every version of an object's blob is stored.
+func ObjectS3Prefix(tenantId string, tableHash string, objectId string) string {
+	return tableS3Prefix(tenantId, tableHash) + objectId + "/"
+}
+
+// tableS3Prefix returns the key prefix under which the blobs of every object of a table are stored.
+func tableS3Prefix(tenantId string, tableHash string) string {
+	return "tenant-" + tenantId + "/" + tableHash + "/"
+}
+
 func NewS3Client(ctx context.Context, bucket string, cfg aws.Config, useLocalstack bool, localstackUrl string) *S3Client {
 	// add localstack endpoint resolver if needed
 	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
@@ -39,23 +51,38 @@ func (s *S3Client) TestConnnectivity(ctx context.Context) error {
 }
 
 /*
-PresignPutObjectUrl generates a presigned URL for uploading an object to S3.
+PresignPutObjectUrl generates a presigned URL for uploading a blob to S3.
+The URL is signed for exactly size bytes and, if checksum is set, for that base64 SHA-256 of the content, which S3 verifies on upload.
+Returns the headers the upload must send as signed, besides Host and Content-Length.
 */
-func (s *S3Client) PresignPutObjectUrl(ctx context.Context, key string, lifetime time.Duration) (string, error) {
-	request, err := s.presigner.PresignPutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(s.Bucket),
-		Key:         aws.String(key),
-		ContentType: aws.String("application/json"),
-	}, func(opts *s3.PresignOptions) {
+func (s *S3Client) PresignPutObjectUrl(ctx context.Context, key string, size int64, checksum string, lifetime time.Duration) (string, map[string]string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String("application/json"),
+		ContentLength: aws.Int64(size),
+	}
+	if checksum != "" {
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+	request, err := s.presigner.PresignPutObject(ctx, input, func(opts *s3.PresignOptions) {
 		opts.Expires = lifetime
 	})
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	if request.URL == "" {
-		return "", errors.New("failed to generate presigned URL")
+		return "", nil, errors.New("failed to generate presigned URL")
 	}
-	return request.URL, nil
+	// the signer keys the signed headers in lower case
+	headers := make(map[string]string, len(request.SignedHeader))
+	for name, values := range request.SignedHeader {
+		if name == "host" || name == "content-length" {
+			continue
+		}
+		headers[name] = strings.Join(values, ",")
+	}
+	return request.URL, headers, nil
 }
 
 func (s *S3Client) PresignGetObjectUrl(ctx context.Context, key string, lifetime time.Duration) (string, error) {
@@ -74,22 +101,98 @@ func (s *S3Client) PresignGetObjectUrl(ctx context.Context, key string, lifetime
 	return request.URL, nil
 }
 
-func (s *S3Client) CheckObjectExists(ctx context.Context, key string) (bool, error) {
-	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(key),
+// BlobInfo is what HeadBlob reports about a stored blob.
+type BlobInfo struct {
+	Size           int64
+	ChecksumSHA256 string // set if the blob was uploaded in a single part with a SHA-256 checksum
+}
+
+// HeadBlob returns the size and checksum of the current version of a blob, or nil if it does not exist.
+func (s *S3Client) HeadBlob(ctx context.Context, key string) (*BlobInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(key),
+		ChecksumMode: s3Types.ChecksumModeEnabled,
 	})
 	if err != nil {
 		var notFoundErr *s3Types.NotFound
 		if errors.As(err, &notFoundErr) {
-			return false, nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &BlobInfo{
+		Size:           aws.ToInt64(out.ContentLength),
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+	}, nil
+}
+
+// ListKeys calls fn for the current version of every blob under a key prefix, stopping at the first error fn returns.
+func (s *S3Client) ListKeys(ctx context.Context, prefix string, fn func(key string, size int64, lastModified time.Time) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			if err := fn(aws.ToString(obj.Key), aws.ToInt64(obj.Size), aws.ToTime(obj.LastModified)); err != nil {
+				return err
+			}
 		}
-		return false, err
 	}
-	return true, nil
+	return nil
+}
+
+/*
+deletionRetentionClasses are the retention periods, in days, that the bucket has a lifecycle rule for, see s3.tf.
+A lifecycle rule filters on a fixed tag value, so a retention is rounded up to the nearest class.
+Lifecycle expiration counts from the creation of a blob, not from its tagging: only blobs that no object references may be tagged for it,
+the blobs of soft-deleted objects are purged by the outbox once the object leaves the trash.
+*/
+var deletionRetentionClasses = []int{1, 7, 14, 30, 60, 90, 180, 365, 730, 1095, 1825, 3650}
+
+// deletionStatusTag returns the value of the status tag that makes the lifecycle rule of retentionDays' class expire a blob, e.g. "deleted-30d".
+func deletionStatusTag(retentionDays int) string {
+	class := deletionRetentionClasses[len(deletionRetentionClasses)-1]
+	for _, days := range deletionRetentionClasses {
+		if days >= retentionDays {
+			class = days
+			break
+		}
+	}
+	return fmt.Sprintf("deleted-%dd", class)
+}
+
+// TagForDeletion tags an unreferenced blob so that the bucket lifecycle rules expire it, together with its noncurrent versions, retentionDays after its creation.
+func (s *S3Client) TagForDeletion(ctx context.Context, key string, retentionDays int) error {
+	_, err := s.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Tagging: &s3Types.Tagging{
+			TagSet: []s3Types.Tag{
+				{
+					Key:   aws.String("status"),
+					Value: aws.String(deletionStatusTag(retentionDays)),
+				},
+				{
+					Key:   aws.String("deleted_at"),
+					Value: aws.String(time.Now().Format(time.RFC3339)),
+				},
+			},
+		},
+	})
+	return err
 }
 
-func (s *S3Client) TagForDeletion(ctx context.Context, key string) error {
+/*
+TagDeleted tags the blob of a soft-deleted object with its deletion and purge times, for reporting only: no lifecycle rule matches the tag,
+the blob is purged by the outbox task scheduled with the delete.
+*/
+func (s *S3Client) TagDeleted(ctx context.Context, key string, purgeAt time.Time) error {
 	_, err := s.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key),
@@ -103,12 +206,17 @@ func (s *S3Client) TagForDeletion(ctx context.Context, key string) error {
 					Key:   aws.String("deleted_at"),
 					Value: aws.String(time.Now().Format(time.RFC3339)),
 				},
+				{
+					Key:   aws.String("purge_at"),
+					Value: aws.String(purgeAt.Format(time.RFC3339)),
+				},
 			},
 		},
 	})
 	return err
 }
 
+// UntagForDeletion removes the deletion tags of a blob whose object was recovered.
 func (s *S3Client) UntagForDeletion(ctx context.Context, key string) error {
 	_, err := s.client.DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{
 		Bucket: aws.String(s.Bucket),
@@ -116,3 +224,66 @@ func (s *S3Client) UntagForDeletion(ctx context.Context, key string) error {
 	})
 	return err
 }
+
+/*
+PurgePrefix permanently deletes every object under a key prefix, including all noncurrent versions and delete markers of the versioned bucket.
+Returns the number of object versions deleted.
+*/
+func (s *S3Client) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted, _, err := s.purgeVersions(ctx, prefix, func(string) bool { return true })
+	return deleted, err
+}
+
+// PurgeKey permanently deletes every version and delete marker of a single key. Returns the number of versions deleted and their total size in bytes.
+func (s *S3Client) PurgeKey(ctx context.Context, key string) (int, int64, error) {
+	// listing by prefix also returns longer keys, e.g. .../v10 for .../v1
+	return s.purgeVersions(ctx, key, func(listed string) bool { return listed == key })
+}
+
+// purgeVersions deletes the versions and delete markers listed under prefix whose key is accepted by match.
+func (s *S3Client) purgeVersions(ctx context.Context, prefix string, match func(key string) bool) (int, int64, error) {
+	deleted := 0
+	var size int64
+	paginator := s3.NewListObjectVersionsPaginator(s.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, size, err
+		}
+		ids := make([]s3Types.ObjectIdentifier, 0, len(page.Versions)+len(page.DeleteMarkers))
+		var pageSize int64
+		for _, version := range page.Versions {
+			if match(aws.ToString(version.Key)) {
+				ids = append(ids, s3Types.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+				pageSize += aws.ToInt64(version.Size)
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if match(aws.ToString(marker.Key)) {
+				ids = append(ids, s3Types.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+			}
+		}
+		// a listing page holds at most 1000 entries, the DeleteObjects limit, but versions and markers are counted separately
+		for i := 0; i < len(ids); i += 1000 {
+			out, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(s.Bucket),
+				Delete: &s3Types.Delete{
+					Objects: ids[i:min(i+1000, len(ids))],
+					Quiet:   aws.Bool(true),
+				},
+			})
+			if err != nil {
+				return deleted, size, err
+			}
+			if len(out.Errors) > 0 {
+				return deleted, size, fmt.Errorf("failed to delete %s: %s", aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
+			}
+			deleted += min(i+1000, len(ids)) - i
+		}
+		size += pageSize
+	}
+	return deleted, size, nil
+}
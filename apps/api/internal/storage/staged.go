@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// stagedWriteTimeout bounds a staged write once its object is pending. The lease of the pending object is twice as long,
+// so that the outbox worker only settles a write whose writer has given up on it.
+const stagedWriteTimeout = 2 * time.Minute
+
+// fitsInOneTransaction reports whether an object write along with the given number of index mutations and other items can be applied in a single transaction.
+func fitsInOneTransaction(indexWrites int, extraItems int) bool {
+	return 1+indexWrites+extraItems <= maxTransactItems
+}
+
+// stagedIndexes snapshots the index entries of a write request, stripped of the object ID like the entries of history versions.
+func stagedIndexes(indexes []objects.Index) []models.VersionIndex {
+	snapshot := make([]models.VersionIndex, 0, len(indexes))
+	for _, idx := range indexes {
+		token := make([]byte, 0, len(idx.TokenHash)+len(idx.TokenRange))
+		token = append(append(token, idx.TokenHash...), idx.TokenRange...)
+		snapshot = append(snapshot, models.VersionIndex{Name: idx.GetIndexName(), Token: token})
+	}
+	return snapshot
+}
+
+/*
+beginStagedWrite schedules the settlement of a staged write of obj at the given version, before the object is made pending,
+so that a write interrupted by an error or a crash is settled by the outbox worker once its lease expires.
+Returns the lease to store on the pending object and a context bounded by stagedWriteTimeout for the remaining steps of the write.
+*/
+func (d *DynamoClient) beginStagedWrite(ctx context.Context, obj *models.Object, version int32) (context.Context, context.CancelFunc, int64, error) {
+	now := time.Now()
+	leaseUntil := now.Add(2 * stagedWriteTimeout)
+	task := models.NewOutboxTask(uuid.NewString(), models.OutboxTaskSettleStaged, obj.GetTenantID(), obj.GetTableHash(), obj.GetObjectID(), version, leaseUntil)
+	taskPut, err := d.outboxTaskPut(task)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if _, err := d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: taskPut.Put.TableName,
+		Item:      taskPut.Put.Item,
+	}); err != nil {
+		return nil, nil, 0, err
+	}
+	stagedCtx, cancel := context.WithDeadline(ctx, now.Add(stagedWriteTimeout))
+	return stagedCtx, cancel, leaseUntil.Unix(), nil
+}
+
+/*
+SettleStagedWrite finishes a staged write of an object version that was not committed before its lease expired.
+The index entries of the object are brought in line with the snapshot kept on the pending item, and the object is flipped to ready:
+an interrupted create is completed with the indexes it was written with, while an interrupted update is undone, since the pending item
+still holds the version being replaced. Returns whether a write was settled; an object that is not pending at that version,
+or whose lease is still running, is left untouched.
+*/
+func (d *DynamoClient) SettleStagedWrite(ctx context.Context, tenantId string, tableHash string, objectId string, version int32) (bool, error) {
+	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId, true)
+	if err != nil || obj == nil {
+		return false, err
+	}
+	if obj.Status != models.StatusPending || obj.Version != version || obj.StagedUntil > time.Now().Unix() {
+		return false, nil
+	}
+
+	// the lease is long expired, gsi1 reflects every index write of the interrupted writer
+	currentIndexes, err := d.GetIndexesByObjectID(ctx, tenantId, tableHash, objectId)
+	if err != nil {
+		return false, err
+	}
+	indexShards, err := d.GetIndexShards(ctx, tenantId, tableHash)
+	if err != nil {
+		return false, err
+	}
+	indexes := make([]objects.Index, 0, len(obj.StagedIndexes))
+	for _, snapshot := range obj.StagedIndexes {
+		indexes = append(indexes, versionIndexToIndex(snapshot))
+	}
+	deletes, puts, err := diffIndexes(tenantId, tableHash, obj, currentIndexes, indexes, indexShards)
+	if err != nil {
+		return false, err
+	}
+	if err := d.batchWrite(ctx, d.IndexesTable, indexWriteRequests(deletes, puts)); err != nil {
+		return false, err
+	}
+
+	err = d.commitStagedObject(ctx, obj.PK, obj.SK, obj.Version, models.StatusReady, obj.StagedUntil)
+	if errors.Is(err, ErrVersionMismatch) {
+		// settled concurrently
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// updateConflict tells why an object could not be updated from the expected version, from a consistent read of the object.
+func (d *DynamoClient) updateConflict(ctx context.Context, tenantId string, tableHash string, objectId string) error {
+	obj, err := d.GetObject(ctx, tenantId, tableHash, objectId, true)
+	switch {
+	case err != nil:
+		return err
+	case obj == nil:
+		return ErrNotFound
+	case obj.Status == models.StatusDeleted:
+		return ErrNotFoundOrDeleted
+	case obj.Status == models.StatusPending:
+		return ErrPending
+	}
+	return ErrVersionMismatch
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+func TestFitsInOneTransaction(t *testing.T) {
+	tests := []struct {
+		indexWrites int
+		extraItems  int
+		want        bool
+	}{
+		{indexWrites: 0, extraItems: 0, want: true},
+		{indexWrites: 99, extraItems: 0, want: true},
+		{indexWrites: 100, extraItems: 0, want: false},
+		{indexWrites: 98, extraItems: 1, want: true},
+		{indexWrites: 99, extraItems: 1, want: false},
+		{indexWrites: 97, extraItems: 2, want: true},
+		{indexWrites: 98, extraItems: 2, want: false},
+	}
+	for _, tt := range tests {
+		if got := fitsInOneTransaction(tt.indexWrites, tt.extraItems); got != tt.want {
+			t.Errorf("fitsInOneTransaction(%d, %d) = %v, want %v", tt.indexWrites, tt.extraItems, got, tt.want)
+		}
+	}
+}
+
+func TestStagedIndexesRoundTrip(t *testing.T) {
+	rangeField := "age"
+	indexes := []objects.Index{
+		testIndex(t, "email", 1),
+		{
+			Name:       objects.IndexName{HashField: "name", RangeField: &rangeField},
+			TokenHash:  bytes.Repeat([]byte{2}, models.DETHashValueLength),
+			TokenRange: bytes.Repeat([]byte{3}, models.OPERangeValueLength),
+		},
+	}
+	objectId := uuid.NewString()
+
+	snapshot := stagedIndexes(indexes)
+	if len(snapshot) != len(indexes) {
+		t.Fatalf("Expected %d staged indexes, got %d", len(indexes), len(snapshot))
+	}
+	for i, staged := range snapshot {
+		want, err := objectIndexToken(indexes[i], objectId)
+		if err != nil {
+			t.Fatalf("Failed to build index token: %v", err)
+		}
+		restored := versionIndexToIndex(staged)
+		if restored.GetIndexName() != indexes[i].GetIndexName() {
+			t.Errorf("Expected index name %q, got %q", indexes[i].GetIndexName(), restored.GetIndexName())
+		}
+		got, err := objectIndexToken(restored, objectId)
+		if err != nil {
+			t.Fatalf("Failed to build restored index token: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Expected restored token of %q to match the written one", staged.Name)
+		}
+	}
+}
+
+func TestStagedIndexesMatchVersionIndexes(t *testing.T) {
+	obj := models.NewObject(testTenantID, testTableHash, uuid.NewString(), nil, nil, nil)
+	indexes := []objects.Index{testIndex(t, "email", 1), testIndex(t, "city", 5)}
+
+	// an interrupted update snapshots the entries it replaces, an interrupted create the entries it writes: both must describe the same entries
+	fromEntries := versionIndexes(testCurrentIndexes(t, obj, indexes, nil))
+	fromRequest := stagedIndexes(indexes)
+	tokens := make(map[string][]byte, len(fromEntries))
+	for _, idx := range fromEntries {
+		tokens[idx.Name] = idx.Token
+	}
+	for _, idx := range fromRequest {
+		if !bytes.Equal(tokens[idx.Name], idx.Token) {
+			t.Errorf("Expected the staged token of %q to match its entry", idx.Name)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/api/objects"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+/*
+TransactOp is one operation of a multi-object transaction.
+Creates carry the new object in Object, updates carry Apply, which is applied to the object read at ExpectedVersion like in UpdateObjectWithIndexes.
+Deletes and condition checks only need TableHash, ObjectID and ExpectedVersion.
+*/
+type TransactOp struct {
+	Type            string // objects.TransactPut, objects.TransactDelete or objects.TransactConditionCheck
+	TableHash       string
+	ObjectID        string
+	ExpectedVersion int32
+	Object          *models.Object
+	Apply           func(*models.Object)
+	Indexes         []objects.Index
+	RetentionDays   int // deletes only, days the object stays recoverable
+}
+
+// TransactError reports the operation that made a transaction fail, Err is one of the storage sentinel errors.
+type TransactError struct {
+	Operation int
+	Err       error
+}
+
+func (e *TransactError) Error() string {
+	return fmt.Sprintf("transaction operation %d: %v", e.Operation, e.Err)
+}
+
+func (e *TransactError) Unwrap() error {
+	return e.Err
+}
+
+/*
+TransactObjects applies puts, deletes and condition checks on objects of the same tenant, possibly in different tables, in a single TransactWriteItems call.
+Every operation on an existing object is conditioned on its expected version and ready status, and index changes are part of the same transaction;
+deletes enqueue their index cleanup in the outbox like SoftDeleteObjectAndIndexes. Either every operation is applied or none is.
+Returns the objects as written, nil for condition checks, or a *TransactError wrapping ErrNotFound, ErrNotFoundOrDeleted, ErrPending,
+ErrVersionMismatch or ErrAlreadyExists for the first operation that failed. Returns ErrTransactionTooLarge if the operations and
+their index changes do not fit in a single transaction.
+*/
+func (d *DynamoClient) TransactObjects(ctx context.Context, tenantId string, ops []TransactOp) ([]*models.Object, error) {
+	now := time.Now().UTC()
+	results := make([]*models.Object, len(ops))
+	tableConfigs := make(map[string]*models.TableConfig)
+	tableConfig := func(tableHash string) (*models.TableConfig, error) {
+		if config, ok := tableConfigs[tableHash]; ok {
+			return config, nil
+		}
+		config, err := d.getTableWriteConfig(ctx, tenantId, tableHash)
+		if err != nil {
+			return nil, err
+		}
+		tableConfigs[tableHash] = config
+		return config, nil
+	}
+
+	var twrite []ddbTypes.TransactWriteItem
+	// owners maps each transaction item to the operation it belongs to, primary marks the object item of each operation
+	var owners []int
+	primary := make(map[int]bool, len(ops))
+	appendItems := func(op int, items ...ddbTypes.TransactWriteItem) {
+		primary[len(twrite)] = true
+		for range items {
+			owners = append(owners, op)
+		}
+		twrite = append(twrite, items...)
+	}
+
+	for i, op := range ops {
+		switch {
+		case op.Type == objects.TransactPut && op.Object != nil:
+			items, err := d.createTransactItems(op, tableConfig)
+			if err != nil {
+				return nil, err
+			}
+			appendItems(i, items...)
+			results[i] = op.Object
+
+		case op.Type == objects.TransactPut:
+			obj, err := d.readForTransact(ctx, tenantId, op)
+			if err != nil {
+				return nil, &TransactError{Operation: i, Err: err}
+			}
+			items, err := d.updateTransactItems(ctx, tenantId, obj, op, tableConfig, now)
+			if err != nil {
+				return nil, err
+			}
+			appendItems(i, items...)
+			results[i] = obj
+
+		case op.Type == objects.TransactDelete:
+			obj, err := d.readForTransact(ctx, tenantId, op)
+			if err != nil {
+				return nil, &TransactError{Operation: i, Err: err}
+			}
+			indexes, err := d.GetIndexesByObjectID(ctx, tenantId, op.TableHash, op.ObjectID)
+			if err != nil {
+				return nil, err
+			}
+			items, err := d.softDeleteItems(obj, indexes, op.RetentionDays, now)
+			if err != nil {
+				return nil, err
+			}
+			appendItems(i, items...)
+			obj.Status = models.StatusDeleted
+			obj.Version++
+			obj.UpdatedAt = now
+			results[i] = obj
+
+		case op.Type == objects.TransactConditionCheck:
+			appendItems(i, ddbTypes.TransactWriteItem{
+				ConditionCheck: &ddbTypes.ConditionCheck{
+					TableName: aws.String(d.ObjectsTable),
+					Key: map[string]ddbTypes.AttributeValue{
+						"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectPK(tenantId, op.TableHash)},
+						"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectSK(op.ObjectID)},
+					},
+					ConditionExpression: aws.String("attribute_exists(pk) AND #v = :expected AND #s = :ready AND " + notExpiredFilter),
+					ExpressionAttributeNames: map[string]string{
+						"#v": "version",
+						"#s": "status",
+					},
+					ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+						":expected": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", op.ExpectedVersion)},
+						":ready":    &ddbTypes.AttributeValueMemberS{Value: models.StatusReady},
+						":now":      &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Unix())},
+					},
+				},
+			})
+
+		default:
+			return nil, fmt.Errorf("unknown transaction operation type %q", op.Type)
+		}
+	}
+
+	if len(twrite) > maxTransactItems {
+		return nil, ErrTransactionTooLarge
+	}
+
+	err := d.transactWrite(ctx, twrite)
+	if item := cancelledItemIndex(err, "ConditionalCheckFailed"); item >= 0 {
+		op := owners[item]
+		if (ops[op].Type == objects.TransactPut && ops[op].Object != nil) || !primary[item] {
+			return nil, &TransactError{Operation: op, Err: ErrAlreadyExists}
+		}
+		return nil, &TransactError{Operation: op, Err: ErrVersionMismatch}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// pruning is best effort, the next update of each object retries it
+	for _, op := range ops {
+		if op.Type == objects.TransactPut && op.Object == nil {
+			_ = d.pruneHistory(ctx, tenantId, op.TableHash, op.ObjectID, tableConfigs[op.TableHash].HistoryMaxVersions)
+		}
+	}
+	return results, nil
+}
+
+// readForTransact reads the object targeted by an update or delete and checks that it is ready at the expected version.
+func (d *DynamoClient) readForTransact(ctx context.Context, tenantId string, op TransactOp) (*models.Object, error) {
+	obj, err := d.GetObject(ctx, tenantId, op.TableHash, op.ObjectID, true)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case obj == nil:
+		return nil, ErrNotFound
+	case obj.Status == models.StatusDeleted:
+		return nil, ErrNotFoundOrDeleted
+	case obj.Status == models.StatusPending:
+		return nil, ErrPending
+	case obj.Version != op.ExpectedVersion:
+		return nil, ErrVersionMismatch
+	}
+	return obj, nil
+}
+
+// createTransactItems returns the object put and index puts of a create, each conditioned on the item not existing yet, followed by the expiry task if the object expires.
+func (d *DynamoClient) createTransactItems(op TransactOp, tableConfig func(string) (*models.TableConfig, error)) ([]ddbTypes.TransactWriteItem, error) {
+	obj := op.Object
+	objMap, err := attributevalue.MarshalMap(obj)
+	if err != nil {
+		return nil, err
+	}
+	notExists := aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)")
+	twrite := []ddbTypes.TransactWriteItem{{
+		Put: &ddbTypes.Put{
+			TableName:           aws.String(d.ObjectsTable),
+			Item:                objMap,
+			ConditionExpression: notExists,
+		},
+	}}
+	expiryTask, err := d.expiryTaskPut(obj)
+	if err != nil {
+		return nil, err
+	}
+	if expiryTask != nil {
+		twrite = append(twrite, *expiryTask)
+	}
+	if len(op.Indexes) == 0 {
+		return twrite, nil
+	}
+
+	config, err := tableConfig(op.TableHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range op.Indexes {
+		token, err := objectIndexToken(idx, obj.GetObjectID())
+		if err != nil {
+			return nil, err
+		}
+		index := models.NewIndex(idx.GetIndexName(), obj.GetTenantID(), op.TableHash, token, obj.GetObjectID(), obj.Version, obj.S3Key, config.IndexShards[idx.GetIndexName()])
+		item, err := attributevalue.MarshalMap(index)
+		if err != nil {
+			return nil, err
+		}
+		twrite = append(twrite, ddbTypes.TransactWriteItem{
+			Put: &ddbTypes.Put{
+				TableName:           aws.String(d.IndexesTable),
+				Item:                item,
+				ConditionExpression: notExists,
+			},
+		})
+	}
+	return twrite, nil
+}
+
+// updateTransactItems applies the update to obj and returns its conditioned put followed by the index mutations, the archived version and the expiry task, like UpdateObjectWithIndexes.
+func (d *DynamoClient) updateTransactItems(ctx context.Context, tenantId string, obj *models.Object, op TransactOp, tableConfig func(string) (*models.TableConfig, error), now time.Time) ([]ddbTypes.TransactWriteItem, error) {
+	currentIndexes, err := d.GetIndexesByObjectID(ctx, tenantId, op.TableHash, op.ObjectID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := tableConfig(op.TableHash)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := *obj
+	op.Apply(obj)
+
+	deletes, puts, err := diffIndexes(tenantId, op.TableHash, obj, currentIndexes, op.Indexes, config.IndexShards)
+	if err != nil {
+		return nil, err
+	}
+	historyItem, err := d.historyPut(config, &previous, currentIndexes, now)
+	if err != nil {
+		return nil, err
+	}
+	item, err := attributevalue.MarshalMap(obj)
+	if err != nil {
+		return nil, err
+	}
+	twrite := []ddbTypes.TransactWriteItem{{
+		Put: &ddbTypes.Put{
+			TableName:           aws.String(d.ObjectsTable),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk) AND #v = :current AND #s = :ready"),
+			ExpressionAttributeNames: map[string]string{
+				"#v": "version",
+				"#s": "status",
+			},
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":current": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", op.ExpectedVersion)},
+				":ready":   &ddbTypes.AttributeValueMemberS{Value: models.StatusReady},
+			},
+		},
+	}}
+	twrite = append(twrite, indexTransactItems(d.IndexesTable, deletes, puts)...)
+	if historyItem != nil {
+		twrite = append(twrite, *historyItem)
+	}
+	expiryTask, err := d.expiryTaskPut(obj)
+	if err != nil {
+		return nil, err
+	}
+	if expiryTask != nil {
+		twrite = append(twrite, *expiryTask)
+	}
+	return twrite, nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// Maximum number of items in a single TransactWriteItems call
+const maxTransactItems = 100
+
+/*
+transactWrite runs a single TransactWriteItems call.
+If the transaction is throttled or conflicts with another in-flight transaction, it is retried with exponential backoff.
+Cancellations caused by a failed condition are returned as is, use cancelledItemIndex to find out which item failed.
+*/
+func (d *DynamoClient) transactWrite(ctx context.Context, items []ddbTypes.TransactWriteItem) error {
+	const maxRetries = 3
+	for attempt := 0; ; attempt++ {
+		_, err := d.Client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		if err == nil || attempt == maxRetries || !isRetryableTransactError(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(100*(1<<attempt)) * time.Millisecond): // exponential backoff
+		}
+	}
+}
+
+func isRetryableTransactError(err error) bool {
+	var throughputErr *ddbTypes.ProvisionedThroughputExceededException
+	if errors.As(err, &throughputErr) {
+		return true
+	}
+	var conflictErr *ddbTypes.TransactionConflictException
+	if errors.As(err, &conflictErr) {
+		return true
+	}
+	var cancelErr *ddbTypes.TransactionCanceledException
+	if errors.As(err, &cancelErr) {
+		retryable := false
+		for _, reason := range cancelErr.CancellationReasons {
+			switch aws.ToString(reason.Code) {
+			case "ConditionalCheckFailed":
+				return false
+			case "TransactionConflict", "ProvisionedThroughputExceeded", "ThrottlingError":
+				retryable = true
+			}
+		}
+		return retryable
+	}
+	return false
+}
+
+// cancelledItemIndex returns the position of the first transaction item cancelled with the given reason code, or -1 if there is none.
+func cancelledItemIndex(err error, code string) int {
+	var cancelErr *ddbTypes.TransactionCanceledException
+	if !errors.As(err, &cancelErr) {
+		return -1
+	}
+	for i, reason := range cancelErr.CancellationReasons {
+		if aws.ToString(reason.Code) == code {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+batchWrite writes the given requests to a table in batches of 25 using BatchWriteItem.
+Unprocessed items returned by DynamoDB are retried with exponential backoff until they are written or the context is cancelled.
+*/
+func (d *DynamoClient) batchWrite(ctx context.Context, table string, requests []ddbTypes.WriteRequest) error {
+	for i := 0; i < len(requests); i += 25 {
+		end := min(i+25, len(requests))
+		remaining := map[string][]ddbTypes.WriteRequest{
+			table: requests[i:end],
+		}
+		retryDelay := 50 * time.Millisecond
+		for len(remaining) > 0 {
+			out, err := d.Client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: remaining,
+			})
+			if err != nil {
+				return err
+			}
+			remaining = out.UnprocessedItems
+			if len(remaining) > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(retryDelay):
+					retryDelay = min(retryDelay*2, 1*time.Second)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+/*
+commitStagedObject is the last step of the staged write protocol used when an object and its index mutations do not fit in a single transaction.
+The object is first written (or flipped) to pending status, which hides it from readers, then the index mutations are applied in batches,
+and finally commitStagedObject flips the status to the given one, provided that nobody else touched the object in the meantime
+and the write still holds its lease; see beginStagedWrite.
+*/
+func (d *DynamoClient) commitStagedObject(ctx context.Context, pk string, sk string, version int32, status string, stagedUntil int64) error {
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: pk},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: sk},
+		},
+		UpdateExpression:    aws.String("SET #s = :status REMOVE staged_indexes, staged_until"),
+		ConditionExpression: aws.String("#s = :pending AND #v = :version AND staged_until = :stagedUntil"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "status",
+			"#v": "version",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":status":      &ddbTypes.AttributeValueMemberS{Value: status},
+			":pending":     &ddbTypes.AttributeValueMemberS{Value: models.StatusPending},
+			":version":     &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", version)},
+			":stagedUntil": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", stagedUntil)},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrVersionMismatch
+		}
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+// ListDeletedObjects returns up to limit soft-deleted objects of a table that have not expired yet, in object ID order, with the paging rules of ScanTable.
+func (d *DynamoClient) ListDeletedObjects(ctx context.Context, tenantId string, tableHash string, limit int, cursor []byte) (*ScanResult, error) {
+	return d.scanObjectsByStatus(ctx, tenantId, tableHash, models.StatusDeleted, limit, cursor, false, false)
+}
+
+/*
+PurgeObject permanently removes a soft-deleted object from DynamoDB: the object item, its remaining index entries and its archived versions.
+The object item is deleted first, conditioned on it still being deleted, so a concurrent recover wins over the purge.
+A purge of an object that no longer exists still removes leftover index entries and versions, so a purge interrupted halfway can be repeated.
+Returns ErrNotDeleted if the object is not soft-deleted. The caller is responsible for removing the blobs from S3.
+*/
+func (d *DynamoClient) PurgeObject(ctx context.Context, tenantId string, tableHash string, objectId string) error {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectPK(tenantId, tableHash)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectSK(objectId)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR #status = :deleted"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":deleted": &ddbTypes.AttributeValueMemberS{Value: models.StatusDeleted},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrNotDeleted
+		}
+		return err
+	}
+
+	if err := d.deleteIndexesByObject(ctx, tenantId, tableHash, objectId); err != nil {
+		return err
+	}
+	return d.deleteObjectVersions(ctx, tenantId, tableHash, objectId)
+}
+
+/*
+PurgeDeletedObject removes a soft-deleted object that left the trash from DynamoDB, like PurgeObject, deletedVersion being the version the delete produced.
+The item is only deleted if it is still deleted at that version and past its TTL, so the task of a delete that was since recovered does nothing;
+a later delete scheduled its own purge. An item already reaped by the DynamoDB TTL still has its leftover entries and versions removed.
+Returns false if the object was not removed. The caller is responsible for removing the blobs from S3.
+*/
+func (d *DynamoClient) PurgeDeletedObject(ctx context.Context, tenantId string, tableHash string, objectId string, deletedVersion int32) (bool, error) {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectPK(tenantId, tableHash)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectSK(objectId)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR (#status = :deleted AND #v = :version AND #ttl <= :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+			"#v":      "version",
+			"#ttl":    "ttl",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":deleted": &ddbTypes.AttributeValueMemberS{Value: models.StatusDeleted},
+			":version": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", deletedVersion)},
+			":now":     &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := d.deleteIndexesByObject(ctx, tenantId, tableHash, objectId); err != nil {
+		return false, err
+	}
+	if err := d.deleteObjectVersions(ctx, tenantId, tableHash, objectId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteObjectVersions removes every archived version of an object.
+func (d *DynamoClient) deleteObjectVersions(ctx context.Context, tenantId string, tableHash string, objectId string) error {
+	var requests []ddbTypes.WriteRequest
+	var startKey map[string]ddbTypes.AttributeValue
+	for {
+		out, err := d.Client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(d.ObjectsTable),
+			KeyConditionExpression: aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+				":pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateHistoryPK(tenantId, tableHash, objectId)},
+			},
+			ProjectionExpression: aws.String("pk, sk"),
+			ConsistentRead:       aws.Bool(true),
+			ExclusiveStartKey:    startKey,
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			requests = append(requests, ddbTypes.WriteRequest{
+				DeleteRequest: &ddbTypes.DeleteRequest{Key: item},
+			})
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return d.batchWrite(ctx, d.ObjectsTable, requests)
+}
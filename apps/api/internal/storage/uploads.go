@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/qodesrl/gardbase/pkg/models"
+)
+
+/*
+ReserveUpload records a large object upload and schedules its sweep at the reservation expiry, in a single transaction.
+Reserving the same object version again replaces the reservation; the sweep of the previous one then finds it unexpired and does nothing.
+*/
+func (d *DynamoClient) ReserveUpload(ctx context.Context, reservation *models.UploadReservation) error {
+	item, err := attributevalue.MarshalMap(reservation)
+	if err != nil {
+		return err
+	}
+	task := models.NewOutboxTask(uuid.NewString(), models.OutboxTaskSweepUpload, reservation.TenantID, reservation.TableHash, reservation.ObjectID, reservation.Version, time.Unix(reservation.ExpiresAt, 0))
+	taskPut, err := d.outboxTaskPut(task)
+	if err != nil {
+		return err
+	}
+	return d.transactWrite(ctx, []ddbTypes.TransactWriteItem{
+		{
+			Put: &ddbTypes.Put{
+				TableName: aws.String(d.ObjectsTable),
+				Item:      item,
+			},
+		},
+		taskPut,
+	})
+}
+
+/*
+ExtendUploadReservation moves the expiry of an unexpired reservation to expiresAt and schedules its sweep then, in a single transaction.
+The sweep scheduled for the previous expiry then finds the reservation unexpired and does nothing.
+Returns ErrUploadNotReserved if the reservation was confirmed, aborted, replaced or expired in the meantime.
+*/
+func (d *DynamoClient) ExtendUploadReservation(ctx context.Context, reservation *models.UploadReservation, expiresAt time.Time) error {
+	task := models.NewOutboxTask(uuid.NewString(), models.OutboxTaskSweepUpload, reservation.TenantID, reservation.TableHash, reservation.ObjectID, reservation.Version, expiresAt)
+	taskPut, err := d.outboxTaskPut(task)
+	if err != nil {
+		return err
+	}
+	err = d.transactWrite(ctx, []ddbTypes.TransactWriteItem{
+		{
+			Update: &ddbTypes.Update{
+				TableName: aws.String(d.ObjectsTable),
+				Key: map[string]ddbTypes.AttributeValue{
+					"pk": &ddbTypes.AttributeValueMemberS{Value: reservation.PK},
+					"sk": &ddbTypes.AttributeValueMemberS{Value: reservation.SK},
+				},
+				UpdateExpression:    aws.String("SET expires_at = :expiresAt"),
+				ConditionExpression: aws.String("expires_at = :current AND expires_at > :now"),
+				ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+					":expiresAt": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", expiresAt.Unix())},
+					":current":   &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", reservation.ExpiresAt)},
+					":now":       &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+				},
+			},
+		},
+		taskPut,
+	})
+	if cancelledItemIndex(err, "ConditionalCheckFailed") == 0 {
+		return ErrUploadNotReserved
+	}
+	if err != nil {
+		return err
+	}
+	reservation.ExpiresAt = expiresAt.Unix()
+	return nil
+}
+
+/*
+ClaimUpload deletes the reservation of an upload that is being confirmed and returns it, so that the sweeper can no longer remove its blob.
+If the confirmation then fails before the object could reference the blob, the caller gives the reservation back with ReserveUpload.
+Returns ErrUploadNotReserved if the upload was never reserved, was already confirmed, or its reservation expired.
+*/
+func (d *DynamoClient) ClaimUpload(ctx context.Context, tenantId string, tableHash string, objectId string, version int32) (*models.UploadReservation, error) {
+	out, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateUploadPK(tenantId, tableHash, objectId)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateUploadSK(version)},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk) AND expires_at > :now"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":now": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+		ReturnValues: ddbTypes.ReturnValueAllOld,
+	})
+	if err != nil {
+		var condErr *ddbTypes.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return nil, ErrUploadNotReserved
+		}
+		return nil, err
+	}
+	var reservation models.UploadReservation
+	if err := attributevalue.UnmarshalMap(out.Attributes, &reservation); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// GetUploadReservation returns the reservation of an upload version that can still be confirmed, nil if it was never reserved, was confirmed or expired.
+func (d *DynamoClient) GetUploadReservation(ctx context.Context, tenantId string, tableHash string, objectId string, version int32) (*models.UploadReservation, error) {
+	out, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateUploadPK(tenantId, tableHash, objectId)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateUploadSK(version)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	var reservation models.UploadReservation
+	if err := attributevalue.UnmarshalMap(out.Item, &reservation); err != nil {
+		return nil, err
+	}
+	if reservation.IsExpired(time.Now()) {
+		return nil, nil
+	}
+	return &reservation, nil
+}
+
+// GetExpiredUpload returns the reservation of an upload version if it expired without being confirmed, nil otherwise.
+func (d *DynamoClient) GetExpiredUpload(ctx context.Context, tenantId string, tableHash string, objectId string, version int32) (*models.UploadReservation, error) {
+	out, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateUploadPK(tenantId, tableHash, objectId)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateUploadSK(version)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	var reservation models.UploadReservation
+	if err := attributevalue.UnmarshalMap(out.Item, &reservation); err != nil {
+		return nil, err
+	}
+	if !reservation.IsExpired(time.Now()) {
+		return nil, nil
+	}
+	return &reservation, nil
+}
+
+/*
+IsBlobReferenced reports whether an object references the given S3 key, from consistent reads of the object item and of all its archived versions,
+expired ones included. An upload whose confirmation wrote the object but failed to answer leaves its reservation behind, so the sweeper must not discard
+a blob before checking it.
+*/
+func (d *DynamoClient) IsBlobReferenced(ctx context.Context, tenantId string, tableHash string, objectId string, s3Key string) (bool, error) {
+	referenced := false
+	check := func(item map[string]ddbTypes.AttributeValue) error {
+		if key, ok := item["s3_key"].(*ddbTypes.AttributeValueMemberS); ok && key.Value == s3Key {
+			referenced = true
+		}
+		return nil
+	}
+	out, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectPK(tenantId, tableHash)},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: models.GenerateObjectSK(objectId)},
+		},
+		ProjectionExpression: aws.String("s3_key"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	if err != nil {
+		return false, err
+	}
+	if out.Item != nil {
+		_ = check(out.Item)
+	}
+	if referenced {
+		return true, nil
+	}
+	if err := d.queryBlobKeys(ctx, models.GenerateHistoryPK(tenantId, tableHash, objectId), check); err != nil {
+		return false, err
+	}
+	return referenced, nil
+}
+
+// DeleteUploadReservation removes a swept reservation, unless it was replaced by a new reservation of the same upload in the meantime.
+func (d *DynamoClient) DeleteUploadReservation(ctx context.Context, reservation *models.UploadReservation) error {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.ObjectsTable),
+		Key: map[string]ddbTypes.AttributeValue{
+			"pk": &ddbTypes.AttributeValueMemberS{Value: reservation.PK},
+			"sk": &ddbTypes.AttributeValueMemberS{Value: reservation.SK},
+		},
+		ConditionExpression: aws.String("expires_at = :expiresAt"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":expiresAt": &ddbTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", reservation.ExpiresAt)},
+		},
+	})
+	var condErr *ddbTypes.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/qodesrl/gardbase/apps/api/internal/metrics"
+	"github.com/qodesrl/gardbase/apps/api/internal/storage"
+	"github.com/qodesrl/gardbase/pkg/models"
+	"go.uber.org/zap"
+)
+
+const (
+	outboxBatchSize   = 25
+	outboxLeaseTTL    = 5 * time.Minute
+	outboxBaseBackoff = 5 * time.Second
+	outboxMaxBackoff  = 1 * time.Hour
+)
+
+/*
+OutboxWorker processes the tasks enqueued in the outbox table.
+Every API server instance runs one: tasks are leased before being processed, so an instance crashing mid-task only delays it until the lease expires.
+Failed tasks are rescheduled with exponential backoff and are never dropped.
+*/
+type OutboxWorker struct {
+	Dynamo       *storage.DynamoClient
+	S3Client     *storage.S3Client
+	Logger       *zap.Logger
+	PollInterval time.Duration
+}
+
+func NewOutboxWorker(dynamo *storage.DynamoClient, s3Client *storage.S3Client, logger *zap.Logger, pollInterval time.Duration) *OutboxWorker {
+	return &OutboxWorker{
+		Dynamo:       dynamo,
+		S3Client:     s3Client,
+		Logger:       logger,
+		PollInterval: pollInterval,
+	}
+}
+
+// Start polls the outbox in a goroutine until ctx is cancelled.
+func (w *OutboxWorker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(w.PollInterval)
+		defer ticker.Stop()
+		for {
+			w.poll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (w *OutboxWorker) poll(ctx context.Context) {
+	now := time.Now()
+	due := 0
+	var oldest time.Time
+	for shard := range models.OutboxShards {
+		tasks, err := w.Dynamo.ListDueOutboxTasks(ctx, shard, now, outboxBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.Logger.Error("Failed to list outbox tasks", zap.Int("shard", shard), zap.Error(err))
+			}
+			continue
+		}
+		due += len(tasks)
+		for i := range tasks {
+			if dueSince := tasks[i].DueSince(); oldest.IsZero() || dueSince.Before(oldest) {
+				oldest = dueSince
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			w.run(ctx, &tasks[i])
+		}
+	}
+
+	metrics.OutboxTasksDue.Set(int64(due))
+	if oldest.IsZero() {
+		metrics.OutboxOldestTaskAgeSeconds.Set(0)
+	} else {
+		metrics.OutboxOldestTaskAgeSeconds.Set(int64(now.Sub(oldest).Seconds()))
+	}
+}
+
+func (w *OutboxWorker) run(ctx context.Context, task *models.OutboxTask) {
+	claimed, err := w.Dynamo.ClaimOutboxTask(ctx, task, time.Now().Add(outboxLeaseTTL))
+	if err != nil {
+		w.Logger.Error("Failed to claim outbox task", zap.String("task_id", task.GetTaskID()), zap.Error(err))
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if err := w.handle(ctx, task); err != nil {
+		metrics.OutboxTaskFailures.Add(1)
+		nextAttemptAt := time.Now().Add(outboxBackoff(task.Attempts + 1))
+		w.Logger.Warn("Outbox task failed, rescheduling",
+			zap.String("task_id", task.GetTaskID()),
+			zap.String("type", task.Type),
+			zap.String("object_id", task.ObjectID),
+			zap.Int("attempts", task.Attempts+1),
+			zap.Time("next_attempt_at", nextAttemptAt),
+			zap.Error(err))
+		if err := w.Dynamo.RescheduleOutboxTask(ctx, task, nextAttemptAt, err.Error()); err != nil {
+			// the lease expires on its own and the task is retried then
+			w.Logger.Error("Failed to reschedule outbox task", zap.String("task_id", task.GetTaskID()), zap.Error(err))
+		}
+		return
+	}
+
+	if err := w.Dynamo.CompleteOutboxTask(ctx, task); err != nil {
+		// tasks are idempotent, running it again once the lease expires is harmless
+		w.Logger.Error("Failed to complete outbox task", zap.String("task_id", task.GetTaskID()), zap.Error(err))
+		return
+	}
+	metrics.OutboxTasksProcessed.Add(1)
+}
+
+func (w *OutboxWorker) handle(ctx context.Context, task *models.OutboxTask) error {
+	switch task.Type {
+	case models.OutboxTaskDeleteIndexes:
+		return w.Dynamo.DeleteIndexesOfDeletedObject(ctx, task.TenantID, task.TableHash, task.ObjectID, task.ObjectVersion)
+	case models.OutboxTaskExpireObject:
+		return w.expireObject(ctx, task)
+	case models.OutboxTaskSweepUpload:
+		return w.sweepUpload(ctx, task)
+	case models.OutboxTaskSettleStaged:
+		return w.settleStaged(ctx, task)
+	case models.OutboxTaskPurgeDeleted:
+		return w.purgeDeleted(ctx, task)
+	default:
+		return fmt.Errorf("unknown outbox task type %q", task.Type)
+	}
+}
+
+// expireObject removes an expired object version from DynamoDB, then every version of its blobs from S3.
+func (w *OutboxWorker) expireObject(ctx context.Context, task *models.OutboxTask) error {
+	expired, err := w.Dynamo.ExpireObject(ctx, task.TenantID, task.TableHash, task.ObjectID, task.ObjectVersion)
+	if err != nil || !expired {
+		return err
+	}
+	if _, err := w.S3Client.PurgePrefix(ctx, storage.ObjectS3Prefix(task.TenantID, task.TableHash, task.ObjectID)); err != nil {
+		return fmt.Errorf("failed to purge expired object blobs from S3: %w", err)
+	}
+	metrics.ObjectsExpired.Add(1)
+	return nil
+}
+
+// purgeDeleted removes a soft-deleted object that left the trash from DynamoDB, then every version of its blobs from S3.
+func (w *OutboxWorker) purgeDeleted(ctx context.Context, task *models.OutboxTask) error {
+	purged, err := w.Dynamo.PurgeDeletedObject(ctx, task.TenantID, task.TableHash, task.ObjectID, task.ObjectVersion)
+	if err != nil || !purged {
+		return err
+	}
+	if _, err := w.S3Client.PurgePrefix(ctx, storage.ObjectS3Prefix(task.TenantID, task.TableHash, task.ObjectID)); err != nil {
+		return fmt.Errorf("failed to purge deleted object blobs from S3: %w", err)
+	}
+	metrics.ObjectsPurged.Add(1)
+	return nil
+}
+
+// settleStaged completes or undoes a staged object write whose writer stopped before committing it.
+func (w *OutboxWorker) settleStaged(ctx context.Context, task *models.OutboxTask) error {
+	settled, err := w.Dynamo.SettleStagedWrite(ctx, task.TenantID, task.TableHash, task.ObjectID, task.ObjectVersion)
+	if err != nil || !settled {
+		return err
+	}
+	metrics.StagedWritesSettled.Add(1)
+	w.Logger.Warn("Settled interrupted staged write",
+		zap.String("tenant_id", task.TenantID),
+		zap.String("object_id", task.ObjectID),
+		zap.Int32("version", task.ObjectVersion))
+	return nil
+}
+
+/*
+sweepUpload removes the blob or multipart upload parts of an upload that was not confirmed before its reservation expired, then the reservation itself.
+A blob that the object or one of its versions references was confirmed after all, only its reservation is removed.
+*/
+func (w *OutboxWorker) sweepUpload(ctx context.Context, task *models.OutboxTask) error {
+	reservation, err := w.Dynamo.GetExpiredUpload(ctx, task.TenantID, task.TableHash, task.ObjectID, task.ObjectVersion)
+	if err != nil || reservation == nil {
+		// confirmed, or reserved again with a later expiry
+		return err
+	}
+	referenced, err := w.Dynamo.IsBlobReferenced(ctx, task.TenantID, task.TableHash, task.ObjectID, reservation.S3Key)
+	if err != nil {
+		return err
+	}
+	if referenced {
+		w.Logger.Warn("Upload reservation left behind by a confirmed upload, keeping its blob",
+			zap.String("tenant_id", task.TenantID),
+			zap.String("object_id", task.ObjectID),
+			zap.Int32("version", task.ObjectVersion))
+		return w.Dynamo.DeleteUploadReservation(ctx, reservation)
+	}
+	uploaded, size, err := w.S3Client.DiscardUpload(ctx, reservation.S3Key, reservation.UploadID)
+	if err != nil {
+		return fmt.Errorf("failed to discard abandoned upload in S3: %w", err)
+	}
+	if err := w.Dynamo.DeleteUploadReservation(ctx, reservation); err != nil {
+		return err
+	}
+
+	if !uploaded {
+		metrics.UploadsExpired.Add(1)
+		return nil
+	}
+	metrics.UploadsAbandoned.Add(1)
+	metrics.UploadsAbandonedBytes.Add(size)
+	w.Logger.Info("Swept abandoned upload",
+		zap.String("tenant_id", task.TenantID),
+		zap.String("object_id", task.ObjectID),
+		zap.Int32("version", task.ObjectVersion),
+		zap.Int64("bytes", size))
+	return nil
+}
+
+// outboxBackoff returns the delay before the given attempt, doubling from outboxBaseBackoff up to outboxMaxBackoff.
+func outboxBackoff(attempt int) time.Duration {
+	if attempt > 20 {
+		return outboxMaxBackoff
+	}
+	return min(outboxBaseBackoff*time.Duration(1<<(attempt-1)), outboxMaxBackoff)
+}
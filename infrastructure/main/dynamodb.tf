@@ -139,3 +139,66 @@ resource "aws_dynamodb_table" "api_keys" {
     Project     = var.project_name
   }
 }
+
+resource "aws_dynamodb_table" "outbox" {
+  name           = "${var.project_name}-outbox-${var.environment}"
+  billing_mode   = "PROVISIONED"
+  read_capacity  = var.environment == "production" ? 5 : 1
+  write_capacity = var.environment == "production" ? 5 : 1
+  hash_key       = "pk"
+  range_key      = "sk"
+
+  attribute {
+    name = "pk"
+    type = "S"
+  }
+  attribute {
+    name = "sk"
+    type = "S"
+  }
+  attribute {
+    name = "next_attempt_at"
+    type = "N"
+  }
+
+  // due tasks of a shard, read by the outbox worker without scanning the tasks scheduled for later
+  global_secondary_index {
+    name            = "gsi1"
+    hash_key        = "pk"
+    range_key       = "next_attempt_at"
+    projection_type = "ALL"
+
+    read_capacity  = var.environment == "production" ? 5 : 1
+    write_capacity = var.environment == "production" ? 5 : 1
+  }
+
+  tags = {
+    Name        = "${var.project_name}-outbox-${var.environment}"
+    Environment = var.environment
+    Project     = var.project_name
+  }
+}
+
+resource "aws_dynamodb_table" "idempotency" {
+  name           = "${var.project_name}-idempotency-${var.environment}"
+  billing_mode   = "PROVISIONED"
+  read_capacity  = var.environment == "production" ? 5 : 1
+  write_capacity = var.environment == "production" ? 5 : 1
+  hash_key       = "pk"
+
+  attribute {
+    name = "pk"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "ttl"
+    enabled        = true
+  }
+
+  tags = {
+    Name        = "${var.project_name}-idempotency-${var.environment}"
+    Environment = var.environment
+    Project     = var.project_name
+  }
+}
@@ -80,12 +80,12 @@ resource "aws_iam_role_policy" "api_policy" {
     Statement = [
       {
         Effect   = "Allow"
-        Action   = ["s3:PutObject", "s3:GetObject", "s3:DeleteObject", "s3:ListBucket", "s3:HeadBucket"]
+        Action   = ["s3:PutObject", "s3:GetObject", "s3:DeleteObject", "s3:ListBucket", "s3:HeadBucket", "s3:ListBucketVersions", "s3:DeleteObjectVersion", "s3:PutObjectTagging", "s3:DeleteObjectTagging", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts"]
         Resource = ["${aws_s3_bucket.uploads.arn}", "${aws_s3_bucket.uploads.arn}/*"]
       },
       {
         Effect = "Allow"
-        Action = ["dynamodb:PutItem", "dynamodb:GetItem", "dynamodb:DeleteItem", "dynamodb:UpdateItem", "dynamodb:Query", "dynamodb:Scan", "dynamodb:DescribeTable", "dynamodb:BatchGetItem"]
+        Action = ["dynamodb:PutItem", "dynamodb:GetItem", "dynamodb:DeleteItem", "dynamodb:UpdateItem", "dynamodb:Query", "dynamodb:Scan", "dynamodb:DescribeTable", "dynamodb:BatchGetItem", "dynamodb:BatchWriteItem", "dynamodb:ConditionCheckItem"]
         Resource = [
           aws_dynamodb_table.objects.arn,
           "${aws_dynamodb_table.objects.arn}/index/*",
@@ -96,7 +96,10 @@ resource "aws_iam_role_policy" "api_policy" {
           aws_dynamodb_table.api_keys.arn,
           "${aws_dynamodb_table.api_keys.arn}/index/*",
           aws_dynamodb_table.table_configs.arn,
-          "${aws_dynamodb_table.table_configs.arn}/index/*"
+          "${aws_dynamodb_table.table_configs.arn}/index/*",
+          aws_dynamodb_table.outbox.arn,
+          "${aws_dynamodb_table.outbox.arn}/index/*",
+          aws_dynamodb_table.idempotency.arn
         ]
       },
       {
@@ -125,7 +128,7 @@ resource "aws_iam_role_policy" "api_policy" {
       },
       {
         Effect   = "Allow"
-        Action   = ["ssm:PutParameter"]
+        Action   = ["ssm:PutParameter", "ssm:GetParameter"]
         Resource = "arn:aws:ssm:${var.region}:${data.aws_caller_identity.current.account_id}:parameter/${var.project_name}/${var.environment}/*"
       }
     ]
@@ -226,6 +229,8 @@ resource "aws_instance" "api" {
     dynamo_table_configs_table  = aws_dynamodb_table.table_configs.name
     dynamo_tenant_configs_table = aws_dynamodb_table.tenant_configs.name
     dynamo_api_keys_table       = aws_dynamodb_table.api_keys.name
+    dynamo_outbox_table         = aws_dynamodb_table.outbox.name
+    dynamo_idempotency_table    = aws_dynamodb_table.idempotency.name
     kms_key_id                  = aws_kms_key.enclave_key.id
     enclave_cpus                = var.enclave_cpus
     enclave_memory_mib          = var.enclave_memory_mib
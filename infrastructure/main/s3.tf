@@ -7,23 +7,51 @@ resource "aws_s3_bucket" "uploads" {
   }
 }
 
+// Retention classes, in days, of unreferenced blobs. Must match deletionRetentionClasses in apps/api/internal/storage/s3.go:
+// blob reconciliation tags an unreferenced blob with status=deleted-<days>d for the smallest class covering the tenant's retention.
+// Expiration counts from the creation of a blob, so these rules must never match the blob of a soft-deleted object: the API tags
+// those with status=deleted for reporting only and purges them from the outbox once the object leaves the trash.
+locals {
+  deletion_retention_classes = [1, 7, 14, 30, 60, 90, 180, 365, 730, 1095, 1825, 3650]
+}
+
 resource "aws_s3_bucket_lifecycle_configuration" "cleanup" {
   bucket = aws_s3_bucket.uploads.id
 
+  dynamic "rule" {
+    for_each = local.deletion_retention_classes
+    content {
+      id     = "delete-tagged-objects-${rule.value}d"
+      status = "Enabled"
+
+      expiration {
+        days = rule.value
+      }
+
+      // the expiration only adds a delete marker to the versioned bucket, the tagged version then becomes noncurrent
+      noncurrent_version_expiration {
+        noncurrent_days = 1
+      }
+
+      filter {
+        tag {
+          key   = "status"
+          value = "deleted-${rule.value}d"
+        }
+      }
+    }
+  }
+
+  // multipart uploads whose reservation was replaced are never aborted by the API
   rule {
-    id     = "delete-tagged-objects"
+    id     = "abort-incomplete-multipart-uploads"
     status = "Enabled"
 
-    expiration {
-      days = 30
+    abort_incomplete_multipart_upload {
+      days_after_initiation = 7
     }
 
-    filter {
-      tag {
-        key   = "status"
-        value = "deleted"
-      }
-    }
+    filter {}
   }
 }
 
@@ -24,6 +24,8 @@ DYNAMO_INDEXES_TABLE="${dynamo_indexes_table}"
 DYNAMO_TABLE_CONFIGS_TABLE="${dynamo_table_configs_table}"
 DYNAMO_TENANT_CONFIGS_TABLE="${dynamo_tenant_configs_table}"
 DYNAMO_API_KEYS_TABLE="${dynamo_api_keys_table}"
+DYNAMO_OUTBOX_TABLE="${dynamo_outbox_table}"
+DYNAMO_IDEMPOTENCY_TABLE="${dynamo_idempotency_table}"
 KMS_KEY_ID="${kms_key_id}"
 ENCLAVE_CPUS="${enclave_cpus}"
 ENCLAVE_MEMORY_MIB="${enclave_memory_mib}"
@@ -34,6 +36,18 @@ TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-meta
 PUBLIC_DNS=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" -s http://169.254.169.254/latest/meta-data/public-hostname)
 echo "Public DNS: '$PUBLIC_DNS'"
 
+# Pagination token secret, generated on first boot and shared by all instances through Parameter Store
+PAGINATION_SECRET_PARAMETER="/$PROJECT_NAME/$ENVIRONMENT/api/pagination-token-secret"
+PAGINATION_TOKEN_SECRET=$(aws ssm get-parameter --region "$REGION" --name "$PAGINATION_SECRET_PARAMETER" --with-decryption --query Parameter.Value --output text 2>/dev/null || true)
+if [ -z "$PAGINATION_TOKEN_SECRET" ]; then
+    PAGINATION_TOKEN_SECRET=$(openssl rand -hex 32)
+    aws ssm put-parameter \
+        --region "$REGION" \
+        --name "$PAGINATION_SECRET_PARAMETER" \
+        --type "SecureString" \
+        --value "$PAGINATION_TOKEN_SECRET"
+fi
+
 echo "Configuration:"
 echo "  Region: $REGION"
 echo "  Environment: $ENVIRONMENT"
@@ -131,6 +145,9 @@ ExecStart=/usr/bin/docker run --rm --name gardbase-parent \\
     -e DYNAMO_TABLE_CONFIGS_TABLE="$DYNAMO_TABLE_CONFIGS_TABLE" \\
     -e DYNAMO_TENANT_CONFIGS_TABLE="$DYNAMO_TENANT_CONFIGS_TABLE" \\
     -e DYNAMO_API_KEYS_TABLE="$DYNAMO_API_KEYS_TABLE" \\
+    -e DYNAMO_OUTBOX_TABLE="$DYNAMO_OUTBOX_TABLE" \\
+    -e DYNAMO_IDEMPOTENCY_TABLE="$DYNAMO_IDEMPOTENCY_TABLE" \\
+    -e PAGINATION_TOKEN_SECRET="$PAGINATION_TOKEN_SECRET" \\
     -e AWS_REGION="$REGION" \\
     -e KMS_KEY_ID="$KMS_KEY_ID" \\
     -e BASE_URL="http://$PUBLIC_DNS" \\
@@ -0,0 +1,40 @@
+package admin
+
+import "github.com/qodesrl/gardbase/pkg/api/objects"
+
+type VerifyIndexesRequest struct {
+	TableHash string `json:"table_hash" binding:"required"`
+	Repair    bool   `json:"repair,omitempty"` // If true, entries pointing at soft-deleted objects are deleted and stale S3 keys are rewritten
+}
+
+// Sharding is opt-in per index and cannot be changed once set. Entries written before it was enabled stay in the unsharded partition and are still found by queries.
+type SetIndexShardsRequest struct {
+	TableHash string            `json:"table_hash" binding:"required"`
+	IndexName objects.IndexName `json:"index_name" binding:"required"`
+	Shards    int               `json:"shards" binding:"required,min=2,max=32"`
+}
+
+// History retention keeps past versions of a table's objects. Both limits zero disables history; versions already archived expire on their own.
+type SetHistoryRetentionRequest struct {
+	TableHash   string `json:"table_hash" binding:"required"`
+	MaxVersions int    `json:"max_versions" binding:"min=0,max=1000"` // Number of past versions kept per object, 0 for no limit
+	MaxAgeDays  int    `json:"max_age_days" binding:"min=0,max=3650"` // Days a past version is kept after being replaced, 0 for no limit
+}
+
+// Soft-delete retention is how long deleted objects stay in the trash. Without a table hash it applies to the whole tenant, with one it overrides the tenant's for that table.
+type SetSoftDeleteRetentionRequest struct {
+	TableHash     string `json:"table_hash,omitempty"`
+	RetentionDays *int   `json:"retention_days" binding:"omitempty,min=0,max=3650"` // 0 purges deleted objects immediately, null removes the setting
+}
+
+// Blob reconciliation modes. A dry run only reports unreferenced blobs, tag hands them to the bucket lifecycle rules and delete removes them at once.
+const (
+	ReconcileModeDryRun = "dry_run"
+	ReconcileModeTag    = "tag"
+	ReconcileModeDelete = "delete"
+)
+
+type ReconcileBlobsRequest struct {
+	TableHash string `json:"table_hash" binding:"required"`
+	Mode      string `json:"mode,omitempty" binding:"omitempty,oneof=dry_run tag delete"` // Defaults to dry_run
+}
@@ -0,0 +1,66 @@
+package admin
+
+import "time"
+
+const (
+	IssueOrphanedIndex = "orphaned_index" // index entry whose object no longer exists
+	IssueDeletedObject = "deleted_object" // index entry pointing at a soft-deleted object
+	IssueStaleS3Key    = "stale_s3_key"   // index entry whose duplicated S3 key differs from the object's
+)
+
+type IndexIssue struct {
+	Type      string `json:"type"`
+	ObjectID  string `json:"object_id"`
+	IndexName string `json:"index_name"`
+	PK        string `json:"pk"`
+	SK        []byte `json:"sk"`
+	Repaired  bool   `json:"repaired"` // False in repair mode if the entry or its object changed since it was checked
+}
+
+type VerifyIndexesResponse struct {
+	TableHash      string       `json:"table_hash"`
+	ObjectsChecked int          `json:"objects_checked"`
+	IndexesChecked int          `json:"indexes_checked"`
+	Issues         []IndexIssue `json:"issues"`
+	Repaired       int          `json:"repaired"`
+}
+
+type SetIndexShardsResponse struct {
+	TableHash string `json:"table_hash"`
+	IndexName string `json:"index_name"`
+	Shards    int    `json:"shards"`
+}
+
+type SetHistoryRetentionResponse struct {
+	TableHash   string `json:"table_hash"`
+	MaxVersions int    `json:"max_versions"`
+	MaxAgeDays  int    `json:"max_age_days"`
+}
+
+type SetSoftDeleteRetentionResponse struct {
+	TableHash     string `json:"table_hash,omitempty"`
+	RetentionDays int    `json:"retention_days"` // retention now in effect, after inheritance
+}
+
+// MaxReportedBlobs caps the blobs listed in a reconciliation report, the counters cover all of them.
+const MaxReportedBlobs = 1000
+
+type UnreferencedBlob struct {
+	Key          string    `json:"key"`
+	ObjectID     string    `json:"object_id"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type ReconcileBlobsResponse struct {
+	TableHash         string             `json:"table_hash"`
+	Mode              string             `json:"mode"`
+	BlobsScanned      int                `json:"blobs_scanned"`
+	BlobsSkipped      int                `json:"blobs_skipped"` // too recent to be reconciled safely
+	Unreferenced      []UnreferencedBlob `json:"unreferenced"`
+	UnreferencedCount int                `json:"unreferenced_count"`
+	UnreferencedBytes int64              `json:"unreferenced_bytes"`
+	Truncated         bool               `json:"truncated"` // true if more than MaxReportedBlobs blobs are unreferenced
+	Tagged            int                `json:"tagged"`
+	Deleted           int                `json:"deleted"`
+}
@@ -0,0 +1,90 @@
+package objects
+
+import "time"
+
+// Limits of the batch endpoints. Requests over MaxBatchRequestBytes are rejected before they are parsed.
+const (
+	MaxBatchGetItems     = 100
+	MaxBatchWriteItems   = 25
+	MaxBatchRequestBytes = 8 << 20
+)
+
+type BatchGetRequest struct {
+	TableHash      string   `json:"table_hash" binding:"required"`
+	ObjectIDs      []string `json:"object_ids" binding:"required,min=1,max=100,dive,required"`
+	ConsistentRead bool     `json:"consistent_read,omitempty"` // If true, the reads reflect all writes acknowledged before them
+}
+
+// BatchGetItem is the result of one object of a batch get, Status is the HTTP status the equivalent single get would have returned.
+type BatchGetItem struct {
+	ObjectID string        `json:"object_id"`
+	Status   int           `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Object   *ResultObject `json:"object,omitempty"`
+}
+
+type BatchGetResponse struct {
+	Items           []BatchGetItem `json:"items"` // In the order of the requested IDs
+	ReadConsistency string         `json:"read_consistency"`
+}
+
+// PresignRequest refreshes the download URLs of large objects, e.g. once those returned by a scan or query expired, without reading the objects again.
+type PresignRequest struct {
+	TableHash string   `json:"table_hash" binding:"required"`
+	ObjectIDs []string `json:"object_ids" binding:"required,min=1,max=100,dive,required"`
+}
+
+// PresignItem is the download URL of one object, for its current version. Status is the HTTP status a single get would have returned, 400 for inline objects.
+type PresignItem struct {
+	ObjectID       string `json:"object_id"`
+	Status         int    `json:"status"`
+	Error          string `json:"error,omitempty"`
+	GetURL         string `json:"get_url,omitempty"`
+	Version        int32  `json:"version,omitempty"`
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
+}
+
+type PresignResponse struct {
+	Items     []PresignItem `json:"items"` // In the order of the requested IDs
+	ExpiresIn int64         `json:"expires_in_seconds"`
+}
+
+// BatchPutItem holds the fields of a PutObjectRequest, the table hash is shared by the whole batch.
+type BatchPutItem struct {
+	ObjectID           string     `json:"object_id,omitempty"` // Optional for updates, auto-generated for new objects
+	EncryptedBlob      []byte     `json:"encrypted_blob" binding:"required"`
+	EncryptedMetadata  []byte     `json:"encrypted_metadata,omitempty"` // Optional, encrypted with the object DEK under its own AAD
+	KMSEncryptedDEK    []byte     `json:"encrypted_dek" binding:"required"`
+	MasterEncryptedDEK []byte     `json:"master_encrypted_dek" binding:"required"`
+	DEKNonce           []byte     `json:"dek_nonce" binding:"required"`
+	Indexes            []Index    `json:"indexes,omitempty"`
+	Sensitivity        string     `json:"sensitivity,omitempty" binding:"omitempty,oneof=low medium high"`
+	Version            int32      `json:"version,omitempty"`                               // 1 = new object, >1 = update
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`                            // Optional, the object is removed at this time
+	TTLSeconds         int64      `json:"ttl_seconds,omitempty" binding:"omitempty,min=1"` // Optional, alternative to expires_at counted from the write
+}
+
+// BatchPutRequest writes up to MaxBatchWriteItems objects. Items are written independently: a failed item does not roll back the others.
+type BatchPutRequest struct {
+	TableHash string         `json:"table_hash" binding:"required"`
+	Items     []BatchPutItem `json:"items" binding:"required,min=1,max=25,dive"`
+}
+
+type BatchDeleteRequest struct {
+	TableHash string   `json:"table_hash" binding:"required"`
+	ObjectIDs []string `json:"object_ids" binding:"required,min=1,max=25,dive,required"`
+}
+
+// BatchWriteResult is the result of one item of a batch put or delete, in the order of the request.
+type BatchWriteResult struct {
+	ObjectID string `json:"object_id,omitempty"` // Empty for a failed create
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Version  int32  `json:"version,omitempty"`
+}
+
+type BatchWriteResponse struct {
+	Items     []BatchWriteResult `json:"items"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+}
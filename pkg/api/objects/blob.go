@@ -0,0 +1,24 @@
+package objects
+
+// GetBlobRequest holds the query parameters of a blob proxy download, the object ID is in the path.
+// The download honors a single-range Range header.
+type GetBlobRequest struct {
+	TableHash string `form:"table_hash" binding:"required"`
+	Version   int32  `form:"version" binding:"omitempty,min=1"` // Optional, a past version kept by the table history
+}
+
+// PutBlobRequest holds the query parameters of a blob proxy upload, the object ID is in the path.
+// The body is the encrypted blob of a reserved upload, or one of its parts for a multipart upload, with a Content-Length of exactly its declared size.
+type PutBlobRequest struct {
+	TableHash  string `form:"table_hash" binding:"required"`
+	Version    int32  `form:"version" binding:"required,min=1"`                // The version reserved by RequestPutLargeObject
+	PartNumber int32  `form:"part_number" binding:"omitempty,min=1,max=10000"` // Required for multipart uploads
+}
+
+type PutBlobResponse struct {
+	ObjectID   string `json:"object_id"`
+	Version    int32  `json:"version"`
+	PartNumber int32  `json:"part_number,omitempty"`
+	ETag       string `json:"etag,omitempty"` // ETag of the part, to pass when confirming the multipart upload
+	Size       int64  `json:"size"`
+}
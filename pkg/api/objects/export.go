@@ -0,0 +1,97 @@
+package objects
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const DefaultExportSegments = 4
+
+type ExportRequest struct {
+	TableHash string `json:"table_hash" binding:"required"`
+	Segments  int    `json:"segments,omitempty" binding:"omitempty,min=1,max=16"` // Number of key ranges read in parallel, defaults to 4
+	// Checkpoints to resume from, keyed by segment, as returned by a previous export with the same number of segments.
+	// Segments without a checkpoint are exported from the start.
+	Checkpoints map[int]string `json:"checkpoints,omitempty"`
+}
+
+// Types of the records of an export stream
+const (
+	ExportRecordObject     = "object"     // an object of the table
+	ExportRecordCheckpoint = "checkpoint" // every object of the segment up to this point has been sent
+	ExportRecordError      = "error"      // the export failed, it can be resumed from the last checkpoints
+	ExportRecordEnd        = "end"        // every segment is done
+)
+
+/*
+ExportRecord is a line of the NDJSON stream returned by /objects/export.
+Records of different segments are interleaved, records of the same segment are in object ID order.
+*/
+type ExportRecord struct {
+	Type       string        `json:"type"`
+	Segment    int           `json:"segment"`
+	Object     *ResultObject `json:"object,omitempty"`
+	Checkpoint string        `json:"checkpoint,omitempty"`
+	Done       bool          `json:"done,omitempty"` // set on the last checkpoint of a segment
+	Error      string        `json:"error,omitempty"`
+}
+
+// ExportReader decodes an export stream and keeps track of the latest checkpoint of every segment, so that an interrupted export can be resumed.
+// A checkpoint expires after the server's export checkpoint lifetime, 7 days by default; an export resumed later must start over.
+type ExportReader struct {
+	dec         *json.Decoder
+	checkpoints map[int]string
+	ended       bool
+}
+
+func NewExportReader(r io.Reader) *ExportReader {
+	return &ExportReader{
+		dec:         json.NewDecoder(bufio.NewReader(r)),
+		checkpoints: make(map[int]string),
+	}
+}
+
+/*
+Next returns the next object of the export. Checkpoint records are consumed internally.
+It returns io.EOF once the server reports the end of the export, io.ErrUnexpectedEOF if the stream stops before that,
+and an error carrying the server message if the export failed. In the last two cases the export can be resumed with Checkpoints;
+objects received after the last checkpoint of a segment are sent again, so consumers should be idempotent on object ID.
+*/
+func (r *ExportReader) Next() (*ResultObject, error) {
+	if r.ended {
+		return nil, io.EOF
+	}
+	for {
+		var record ExportRecord
+		if err := r.dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch record.Type {
+		case ExportRecordObject:
+			if record.Object != nil {
+				return record.Object, nil
+			}
+		case ExportRecordCheckpoint:
+			r.checkpoints[record.Segment] = record.Checkpoint
+		case ExportRecordError:
+			return nil, fmt.Errorf("export failed: %s", record.Error)
+		case ExportRecordEnd:
+			r.ended = true
+			return nil, io.EOF
+		}
+	}
+}
+
+// Checkpoints returns the latest checkpoint of every segment seen so far, to be passed in ExportRequest.Checkpoints to resume the export.
+func (r *ExportReader) Checkpoints() map[int]string {
+	checkpoints := make(map[int]string, len(r.checkpoints))
+	for segment, checkpoint := range r.checkpoints {
+		checkpoints[segment] = checkpoint
+	}
+	return checkpoints
+}
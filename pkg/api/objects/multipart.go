@@ -0,0 +1,65 @@
+package objects
+
+import "time"
+
+// Limits of large object uploads, set by S3. A single-part upload is capped at MaxSinglePartUploadSize, larger blobs must be uploaded in parts.
+const (
+	MaxSinglePartUploadSize = 5 << 30
+	MinUploadPartSize       = 5 << 20
+	MaxUploadPartSize       = 5 << 30
+	DefaultUploadPartSize   = 64 << 20
+	MaxUploadParts          = 10000
+)
+
+// MaxPresignedParts is the number of part URLs handed out per response, the others are requested with ResumePutLargeObjectRequest.FromPart.
+const MaxPresignedParts = 1000
+
+// UploadPart is the presigned URL to PUT one part of a multipart upload to. Parts are numbered from 1 and can be uploaded in parallel.
+// The URL is signed for the part's exact size: PartSize, or the remainder of the blob for the last part.
+type UploadPart struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+// CompletedPart identifies an uploaded part by the ETag header S3 returned for its PUT.
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number" binding:"required,min=1,max=10000"`
+	ETag       string `json:"etag" binding:"required"`
+}
+
+type UploadedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
+
+/*
+ResumePutLargeObjectRequest resumes a reserved upload and extends its reservation: a multipart upload gets new URLs for the parts S3 has not received yet,
+from FromPart on and up to MaxPresignedParts of them, a single-part upload a new URL.
+*/
+type ResumePutLargeObjectRequest struct {
+	ObjectID  string `json:"object_id" binding:"required"`
+	TableHash string `json:"table_hash" binding:"required"`
+	Version   int32  `json:"version" binding:"required,min=1"`
+	FromPart  int32  `json:"from_part,omitempty" binding:"omitempty,min=1,max=10000"`
+}
+
+type ResumePutLargeObjectResponse struct {
+	ObjectID      string            `json:"object_id"`
+	UploadURL     string            `json:"upload_url,omitempty"` // Single-part uploads only
+	UploadHeaders map[string]string `json:"upload_headers,omitempty"`
+	UploadID      string            `json:"upload_id,omitempty"`
+	PartSize      int64             `json:"part_size,omitempty"`
+	UploadedParts []UploadedPart    `json:"uploaded_parts,omitempty"`
+	Parts         []UploadPart      `json:"parts,omitempty"`     // Parts still to upload
+	NextPart      int32             `json:"next_part,omitempty"` // First missing part without a URL yet, to resume from; 0 if every missing part has one
+	ExpiresIn     int64             `json:"expires_in_seconds"`
+	ConfirmBy     time.Time         `json:"confirm_by"`
+}
+
+// AbortPutLargeObjectRequest cancels a reserved upload and deletes whatever was uploaded, the version can then be reserved again.
+type AbortPutLargeObjectRequest struct {
+	ObjectID  string `json:"object_id" binding:"required"`
+	TableHash string `json:"table_hash" binding:"required"`
+	Version   int32  `json:"version" binding:"required,min=1"`
+}
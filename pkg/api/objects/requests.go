@@ -1,5 +1,7 @@
 package objects
 
+import "time"
+
 type GetTableHashRequest struct {
 	SessionID                 string `json:"session_id" binding:"required"`
 	SessionEncryptedTableName []byte `json:"encrypted_table_name,omitempty"`
@@ -45,47 +47,89 @@ func (i *Index) IsHashOnly() bool {
 	return len(i.TokenHash) > 0 && len(i.TokenRange) == 0
 }
 
+// MaxEncryptedMetadataSize is the largest encrypted metadata accepted on an object, in bytes, it is meant for the few fields list views show.
+const MaxEncryptedMetadataSize = 4096
+
+// Projections of scan and query results
+const (
+	ProjectionFull     = "full"     // objects are returned with their blob or download URL
+	ProjectionMetadata = "metadata" // objects are returned with their encrypted metadata and DEKs only, blobs are neither read nor presigned
+)
+
 // If the object is lightweight (e.g. encrypted blob is less than 100KB), the client can include the encrypted blob and DEK in the request body to avoid an extra round trip for uploading the object.
+// At most one of expires_at and ttl_seconds may be set. Each write sets the expiry anew: an update without one makes the object permanent.
 type PutObjectRequest struct {
-	ObjectID           string  `json:"object_id,omitempty"` // Optional for updates, auto-generated for new objects
-	TableHash          string  `json:"table_hash" binding:"required"`
-	EncryptedBlob      []byte  `json:"encrypted_blob" binding:"required"`
-	KMSEncryptedDEK    []byte  `json:"encrypted_dek" binding:"required"`
-	MasterEncryptedDEK []byte  `json:"master_encrypted_dek" binding:"required"`
-	DEKNonce           []byte  `json:"dek_nonce" binding:"required"`
-	Indexes            []Index `json:"indexes,omitempty"`
-	Sensitivity        string  `json:"sensitivity,omitempty" binding:"omitempty,oneof=low medium high"`
-	Version            int32   `json:"version,omitempty"` // 1 = new object, >1 = update
+	ObjectID           string     `json:"object_id,omitempty"` // Optional for updates, auto-generated for new objects
+	TableHash          string     `json:"table_hash" binding:"required"`
+	EncryptedBlob      []byte     `json:"encrypted_blob" binding:"required"`
+	EncryptedMetadata  []byte     `json:"encrypted_metadata,omitempty"` // Optional, encrypted with the object DEK under its own AAD
+	KMSEncryptedDEK    []byte     `json:"encrypted_dek" binding:"required"`
+	MasterEncryptedDEK []byte     `json:"master_encrypted_dek" binding:"required"`
+	DEKNonce           []byte     `json:"dek_nonce" binding:"required"`
+	Indexes            []Index    `json:"indexes,omitempty"`
+	Sensitivity        string     `json:"sensitivity,omitempty" binding:"omitempty,oneof=low medium high"`
+	Version            int32      `json:"version,omitempty"`                               // 1 = new object, >1 = update
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`                            // Optional, the object is removed at this time
+	TTLSeconds         int64      `json:"ttl_seconds,omitempty" binding:"omitempty,min=1"` // Optional, alternative to expires_at counted from the write
 }
 
 // For large objects, the client should first call RequestPutLargeObject to get a pre-signed URL for uploading the encrypted blob, then call ConfirmPutLargeObject to create the object record after the upload is complete.
 type RequestPutLargeObjectRequest struct {
 	ObjectID  string `json:"object_id,omitempty"` // Optional for updates, auto-generated for new objects
 	TableHash string `json:"table_hash" binding:"required"`
-	BlobSize  int64  `json:"blob_size" binding:"required"`
-	Version   int32  `json:"version,omitempty"` // 1 = new object, >1 = update
+	BlobSize  int64  `json:"blob_size" binding:"required"` // Exact size of the encrypted blob, signed into the upload URLs
+	Version   int32  `json:"version,omitempty"`            // 1 = new object, >1 = update
+
+	// base64 SHA-256 of the encrypted blob. It is signed into a single-part upload URL, S3 then rejects a blob that does not match;
+	// for multipart uploads it is only stored on the object, for the client to verify downloads.
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty" binding:"omitempty,base64"`
+
+	// multipart uploads, required over MaxSinglePartUploadSize
+	Multipart bool  `json:"multipart,omitempty"`
+	PartSize  int64 `json:"part_size,omitempty" binding:"omitempty,min=5242880,max=5368709120"` // Defaults to DefaultUploadPartSize
 }
 
 type ConfirmPutLargeObjectRequest struct {
-	ObjectID           string  `json:"object_id" binding:"required"`
-	TableHash          string  `json:"table_hash" binding:"required"`
-	KMSEncryptedDEK    []byte  `json:"encrypted_dek" binding:"required"`
-	MasterEncryptedDEK []byte  `json:"master_encrypted_dek" binding:"required"`
-	DEKNonce           []byte  `json:"dek_nonce" binding:"required"`
-	Indexes            []Index `json:"indexes,omitempty"`
-	Sensitivity        string  `json:"sensitivity,omitempty" binding:"omitempty,oneof=low medium high"`
-	Version            int32   `json:"version,omitempty"` // 1 = new object, >1 = update
+	ObjectID           string     `json:"object_id" binding:"required"`
+	TableHash          string     `json:"table_hash" binding:"required"`
+	KMSEncryptedDEK    []byte     `json:"encrypted_dek" binding:"required"`
+	MasterEncryptedDEK []byte     `json:"master_encrypted_dek" binding:"required"`
+	DEKNonce           []byte     `json:"dek_nonce" binding:"required"`
+	EncryptedMetadata  []byte     `json:"encrypted_metadata,omitempty"` // Optional, encrypted with the object DEK under its own AAD
+	Indexes            []Index    `json:"indexes,omitempty"`
+	Sensitivity        string     `json:"sensitivity,omitempty" binding:"omitempty,oneof=low medium high"`
+	Version            int32      `json:"version,omitempty"`                               // 1 = new object, >1 = update
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`                            // Optional, the object is removed at this time
+	TTLSeconds         int64      `json:"ttl_seconds,omitempty" binding:"omitempty,min=1"` // Optional, alternative to expires_at counted from the write
+
+	// multipart uploads only, every uploaded part is used if omitted
+	Parts []CompletedPart `json:"parts,omitempty" binding:"omitempty,max=10000,dive"`
+}
+
+// PutIndexesRequest upserts index entries for an existing object version without rewriting its blob or bumping its version.
+// It is meant for backfilling indexes on fields that became searchable after the objects were written.
+type PutIndexesRequest struct {
+	ObjectID  string  `json:"object_id" binding:"required"`
+	TableHash string  `json:"table_hash" binding:"required"`
+	Version   int32   `json:"version" binding:"required"` // Current version of the object, the write is rejected if the object has moved on
+	Indexes   []Index `json:"indexes" binding:"required"`
 }
 
+// GetObjectRequest can be sent with an If-None-Match header holding the ETag of a cached copy, the server then responds with 304 if the object did not change.
 type GetObjectRequest struct {
-	TableHash string `json:"table_hash" binding:"required"`
-	ObjectID  string `json:"object_id" binding:"required"`
+	TableHash       string `json:"table_hash" binding:"required"`
+	ObjectID        string `json:"object_id" binding:"required"`
+	ConsistentRead  bool   `json:"consistent_read,omitempty"`  // If true, the read reflects all writes acknowledged before it
+	ExpectedVersion int32  `json:"expected_version,omitempty"` // If set, the read fails with 409 when the object is at another version
+	Version         int32  `json:"version,omitempty"`          // If set, returns this version of the object, the current one or a retained past version
 }
 
 type ScanRequest struct {
-	TableHash string  `json:"table_hash" binding:"required"`
-	Limit     int     `json:"limit,omitempty"`
-	NextToken *string `json:"next_token,omitempty"`
+	TableHash      string  `json:"table_hash" binding:"required"`
+	Limit          int     `json:"limit,omitempty"`
+	NextToken      *string `json:"next_token,omitempty"`
+	ConsistentRead bool    `json:"consistent_read,omitempty"`                                    // If true, the read reflects all writes acknowledged before it
+	Projection     string  `json:"projection,omitempty" binding:"omitempty,oneof=full metadata"` // ProjectionFull if omitted
 }
 
 type DeleteObjectRequest struct {
@@ -117,4 +161,8 @@ type QueryRequest struct {
 	Limit        int           `json:"limit,omitempty"`
 	NextToken    *string       `json:"next_token,omitempty"`
 	ScanForward  bool          `json:"scan_forward,omitempty"`
+	// If true, both the index lookup and the object fetch reflect all writes acknowledged before the query
+	ConsistentRead bool `json:"consistent_read,omitempty"`
+	// ProjectionFull if omitted
+	Projection string `json:"projection,omitempty" binding:"omitempty,oneof=full metadata"`
 }
@@ -1,6 +1,9 @@
 package objects
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type GetTableHashResponse struct {
 	TableHash string `json:"table_hash"`
@@ -11,50 +14,89 @@ type GetTableIEKResponse struct {
 }
 
 type PutObjectResponse struct {
-	ObjectID  string    `json:"object_id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	TableHash string    `json:"table_hash"`
-	Version   int32     `json:"version"`
+	ObjectID  string     `json:"object_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	TableHash string     `json:"table_hash"`
+	Version   int32      `json:"version"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type RequestPutLargeObjectResponse struct {
-	UploadURL       string `json:"upload_url"`
-	ObjectID        string `json:"object_id"`
-	ExpiresIn       int64  `json:"expires_in_seconds"`
-	ExpectedVersion int32  `json:"expected_version"`
+	UploadURL       string    `json:"upload_url"`
+	ObjectID        string    `json:"object_id"`
+	ExpiresIn       int64     `json:"expires_in_seconds"`
+	ExpectedVersion int32     `json:"expected_version"`
+	ConfirmBy       time.Time `json:"confirm_by"` // The upload must be confirmed before this time, or the uploaded blob is deleted
+	// headers the PUT to UploadURL must send as signed, besides a Content-Length of exactly the declared blob size
+	UploadHeaders map[string]string `json:"upload_headers,omitempty"`
+	// set for multipart uploads, UploadURL is then empty
+	UploadID string       `json:"upload_id,omitempty"`
+	PartSize int64        `json:"part_size,omitempty"`
+	Parts    []UploadPart `json:"parts,omitempty"`
+	NextPart int32        `json:"next_part,omitempty"` // First part without a URL yet, see ResumePutLargeObjectRequest.FromPart
 }
 
 type ConfirmPutLargeObjectResponse struct {
-	ObjectID  string    `json:"object_id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	TableHash string    `json:"table_hash"`
-	Version   int32     `json:"version"`
+	ObjectID  string     `json:"object_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	TableHash string     `json:"table_hash"`
+	Version   int32      `json:"version"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type PutIndexesResponse struct {
+	ObjectID string `json:"object_id"`
+	Version  int32  `json:"version"`
+	Written  int    `json:"written"` // Number of index entries created or replaced, unchanged entries are not counted
 }
 
 type ResultObject struct {
-	ObjectID         string    `json:"object_id"`
-	GetURL           string    `json:"get_url"`
-	EncryptedBlob    []byte    `json:"encrypted_blob,omitempty"`
-	KMSWrappedDEK    []byte    `json:"kms_wrapped_dek,omitempty"`
-	MasterWrappedDEK []byte    `json:"master_wrapped_dek,omitempty"`
-	DEKNonce         []byte    `json:"dek_nonce,omitempty"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
-	Version          int32     `json:"version"`
+	ObjectID         string     `json:"object_id"`
+	GetURL           string     `json:"get_url"`
+	EncryptedBlob    []byte     `json:"encrypted_blob,omitempty"`
+	KMSWrappedDEK    []byte     `json:"kms_wrapped_dek,omitempty"`
+	MasterWrappedDEK []byte     `json:"master_wrapped_dek,omitempty"`
+	DEKNonce         []byte     `json:"dek_nonce,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Version          int32      `json:"version"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	ChecksumSHA256   string     `json:"checksum_sha256,omitempty"` // base64 SHA-256 of the blob behind GetURL, if declared at upload
+
+	// decrypted with the same DEK as the blob; with ProjectionMetadata it is returned without the blob and GetURL
+	EncryptedMetadata []byte `json:"encrypted_metadata,omitempty"`
 }
 
-type GetObjectResponse = ResultObject
+// Read consistency reported by read responses
+const (
+	ReadConsistencyStrong   = "strong"   // the result reflects all writes acknowledged before the read
+	ReadConsistencyEventual = "eventual" // the result may miss writes acknowledged shortly before the read
+)
+
+// ObjectETag returns the ETag of an object version, sent by the get endpoint in the ETag header.
+func ObjectETag(objectId string, version int32) string {
+	return fmt.Sprintf(`"%s.%d"`, objectId, version)
+}
+
+type GetObjectResponse struct {
+	ResultObject
+	ReadConsistency string `json:"read_consistency"`
+}
 
 type ScanResponse struct {
-	Objects   []ResultObject `json:"objects"`
-	Count     int            `json:"count"`
-	NextToken *string        `json:"next_token,omitempty"`
+	Objects         []ResultObject `json:"objects"`
+	Count           int            `json:"count"`         // number of objects returned
+	ScannedCount    int            `json:"scanned_count"` // number of items read to collect them, deleted and missing objects included
+	NextToken       *string        `json:"next_token,omitempty"`
+	ReadConsistency string         `json:"read_consistency"`
 }
 
 type QueryResponse struct {
-	Objects   []ResultObject `json:"objects"`
-	Count     int            `json:"count"`
-	NextToken *string        `json:"next_token,omitempty"`
+	Objects         []ResultObject `json:"objects"`
+	Count           int            `json:"count"`         // number of objects returned
+	ScannedCount    int            `json:"scanned_count"` // number of items read to collect them, deleted and missing objects included
+	NextToken       *string        `json:"next_token,omitempty"`
+	ReadConsistency string         `json:"read_consistency"`
 }
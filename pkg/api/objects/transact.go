@@ -0,0 +1,58 @@
+package objects
+
+import "time"
+
+// MaxTransactOperations is the number of operations a transaction accepts. Index changes count towards the 100 items of a DynamoDB transaction as well.
+const MaxTransactOperations = 25
+
+// Operation types of a transaction
+const (
+	TransactPut            = "put"
+	TransactDelete         = "delete"
+	TransactConditionCheck = "condition_check"
+)
+
+/*
+TransactOperation is a single operation of a transaction.
+A put without object ID creates a new object; every other operation targets an existing object that must be at ExpectedVersion.
+Put fields other than TableHash, ObjectID and ExpectedVersion are ignored by deletes and condition checks.
+*/
+type TransactOperation struct {
+	Type               string     `json:"type" binding:"required,oneof=put delete condition_check"`
+	TableHash          string     `json:"table_hash" binding:"required"`
+	ObjectID           string     `json:"object_id,omitempty"`
+	ExpectedVersion    int32      `json:"expected_version,omitempty"` // Current version of the object, omitted for creates
+	EncryptedBlob      []byte     `json:"encrypted_blob,omitempty"`
+	EncryptedMetadata  []byte     `json:"encrypted_metadata,omitempty"`
+	KMSEncryptedDEK    []byte     `json:"encrypted_dek,omitempty"`
+	MasterEncryptedDEK []byte     `json:"master_encrypted_dek,omitempty"`
+	DEKNonce           []byte     `json:"dek_nonce,omitempty"`
+	Indexes            []Index    `json:"indexes,omitempty"`
+	Sensitivity        string     `json:"sensitivity,omitempty" binding:"omitempty,oneof=low medium high"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`                            // Optional, the object is removed at this time
+	TTLSeconds         int64      `json:"ttl_seconds,omitempty" binding:"omitempty,min=1"` // Optional, alternative to expires_at counted from the write
+}
+
+// TransactRequest applies all its operations atomically: either every operation succeeds or none is applied.
+type TransactRequest struct {
+	Operations []TransactOperation `json:"operations" binding:"required,min=1,max=25,dive"`
+}
+
+// TransactResult is the outcome of one operation of a committed transaction, in the order of the request.
+type TransactResult struct {
+	Type      string    `json:"type"`
+	TableHash string    `json:"table_hash"`
+	ObjectID  string    `json:"object_id"`
+	Version   int32     `json:"version"` // Version after the transaction, unchanged for condition checks
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type TransactResponse struct {
+	Results []TransactResult `json:"results"`
+}
+
+// TransactErrorResponse is returned when the transaction is rejected, FailedOperation is the index of the operation that caused it.
+type TransactErrorResponse struct {
+	Error           string `json:"error"`
+	FailedOperation int    `json:"failed_operation"`
+}
@@ -0,0 +1,41 @@
+package objects
+
+import "time"
+
+// DefaultTrashListLimit is the page size of TrashListRequest when no limit is given.
+const DefaultTrashListLimit = 100
+
+// TrashListRequest lists the soft-deleted objects of a table, which can be recovered until they expire.
+type TrashListRequest struct {
+	TableHash string  `json:"table_hash" binding:"required"`
+	Limit     int     `json:"limit,omitempty" binding:"omitempty,min=1,max=1000"`
+	NextToken *string `json:"next_token,omitempty"`
+}
+
+type TrashedObject struct {
+	ObjectID  string     `json:"object_id"`
+	Version   int32      `json:"version"`
+	Large     bool       `json:"large"` // The blob is stored in S3
+	DeletedAt time.Time  `json:"deleted_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // When the object is removed for good unless recovered
+}
+
+type TrashListResponse struct {
+	Objects   []TrashedObject `json:"objects"`
+	NextToken *string         `json:"next_token,omitempty"`
+}
+
+// TrashRecoverRequest recovers up to MaxBatchWriteItems soft-deleted objects, each reported in a BatchWriteResponse item.
+type TrashRecoverRequest struct {
+	TableHash string   `json:"table_hash" binding:"required"`
+	ObjectIDs []string `json:"object_ids" binding:"required,min=1,max=25,dive,required"`
+}
+
+/*
+TrashPurgeRequest permanently erases up to MaxBatchWriteItems soft-deleted objects, each reported in a BatchWriteResponse item.
+The object record, its index entries, its archived versions and every stored version of its blobs are removed right away and cannot be recovered.
+*/
+type TrashPurgeRequest struct {
+	TableHash string   `json:"table_hash" binding:"required"`
+	ObjectIDs []string `json:"object_ids" binding:"required,min=1,max=25,dive,required"`
+}
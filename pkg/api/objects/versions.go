@@ -0,0 +1,46 @@
+package objects
+
+import "time"
+
+// DefaultListVersionsLimit is the page size of ListVersionsRequest when no limit is given.
+const DefaultListVersionsLimit = 50
+
+type ListVersionsRequest struct {
+	TableHash string  `json:"table_hash" binding:"required"`
+	ObjectID  string  `json:"object_id" binding:"required"`
+	Limit     int     `json:"limit,omitempty" binding:"omitempty,min=1,max=1000"`
+	NextToken *string `json:"next_token,omitempty"`
+}
+
+// ObjectVersionInfo describes a version of an object, use GetObjectRequest with Version to load it.
+type ObjectVersionInfo struct {
+	Version    int32      `json:"version"`
+	Current    bool       `json:"current"`
+	Large      bool       `json:"large"` // The blob is stored in S3
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ReplacedAt *time.Time `json:"replaced_at,omitempty"` // Unset for the current version
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`  // When the version is dropped by age-based retention
+}
+
+// ListVersionsResponse lists the current version on the first page, followed by the retained past versions, newest first.
+type ListVersionsResponse struct {
+	Versions  []ObjectVersionInfo `json:"versions"`
+	NextToken *string             `json:"next_token,omitempty"`
+}
+
+// RestoreVersionRequest writes a new version of an object with the content, DEKs and indexes of a retained past version.
+type RestoreVersionRequest struct {
+	TableHash       string `json:"table_hash" binding:"required"`
+	ObjectID        string `json:"object_id" binding:"required"`
+	Version         int32  `json:"version" binding:"required,min=1"`          // Version to restore
+	ExpectedVersion int32  `json:"expected_version" binding:"required,min=1"` // Current version of the object
+}
+
+type RestoreVersionResponse struct {
+	ObjectID     string    `json:"object_id"`
+	TableHash    string    `json:"table_hash"`
+	Version      int32     `json:"version"`
+	RestoredFrom int32     `json:"restored_from"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
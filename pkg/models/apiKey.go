@@ -24,6 +24,7 @@ const (
 	PermissionRead   = "read"
 	PermissionWrite  = "write"
 	PermissionCrypto = "crypto"
+	PermissionAdmin  = "admin"
 )
 
 func NewAPIKey(tenantId string, keyId string, hashedKey string, prefix string, permissions []string, expiresAt *time.Time) *APIKey {
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectVersion is a past version of an object, kept in the objects table when the table has history retention enabled.
+type ObjectVersion struct {
+	PK string `dynamodbav:"pk" json:"pk"` // format: "TENANT#<tenant_id>#TABLE#<table_hash>#HIST#<object_id>"
+	SK string `dynamodbav:"sk" json:"sk"` // format: "V#<zero-padded version>"
+
+	EncryptedBlob    []byte `dynamodbav:"encrypted_blob,omitempty" json:"encrypted_blob,omitempty"`
+	S3Key            string `dynamodbav:"s3_key,omitempty" json:"s3_key,omitempty"`
+	ChecksumSHA256   string `dynamodbav:"checksum_sha256,omitempty" json:"checksum_sha256,omitempty"`
+	KMSWrappedDEK    []byte `dynamodbav:"kms_wrapped_dek,omitempty" json:"kms_wrapped_dek,omitempty"`
+	MasterWrappedDEK []byte `dynamodbav:"master_wrapped_dek,omitempty" json:"master_wrapped_dek,omitempty"`
+	DEKNonce         []byte `dynamodbav:"dek_nonce,omitempty" json:"dek_nonce,omitempty"`
+	Sensitivity      string `dynamodbav:"sensitivity,omitempty" json:"sensitivity,omitempty"`
+
+	EncryptedMetadata []byte `dynamodbav:"encrypted_metadata,omitempty" json:"encrypted_metadata,omitempty"`
+
+	// index entries of the version, so that restoring it also restores its indexes
+	Indexes []VersionIndex `dynamodbav:"indexes,omitempty" json:"indexes,omitempty"`
+
+	Version    int32     `dynamodbav:"version" json:"version"`
+	CreatedAt  time.Time `dynamodbav:"created_at,omitempty" json:"created_at"`
+	UpdatedAt  time.Time `dynamodbav:"updated_at,omitempty" json:"updated_at"`
+	ReplacedAt time.Time `dynamodbav:"replaced_at" json:"replaced_at"`     // When the version stopped being the current one
+	TTL        int64     `dynamodbav:"ttl,omitempty" json:"ttl,omitempty"` // Unix timestamp, set when the table retains history for a number of days
+}
+
+// VersionIndex is an index entry of a past version: the index name and its token without the object ID suffix.
+type VersionIndex struct {
+	Name  string `dynamodbav:"name" json:"name"`
+	Token []byte `dynamodbav:"token" json:"token"`
+}
+
+// NewObjectVersion snapshots obj and its index entries as a past version replaced at the given time.
+func NewObjectVersion(obj *Object, indexes []VersionIndex, replacedAt time.Time, expiresAt time.Time) *ObjectVersion {
+	version := &ObjectVersion{
+		PK:                GenerateHistoryPK(obj.GetTenantID(), obj.GetTableHash(), obj.GetObjectID()),
+		SK:                GenerateHistorySK(obj.Version),
+		EncryptedBlob:     obj.EncryptedBlob,
+		S3Key:             obj.S3Key,
+		ChecksumSHA256:    obj.ChecksumSHA256,
+		EncryptedMetadata: obj.EncryptedMetadata,
+		KMSWrappedDEK:     obj.KMSWrappedDEK,
+		MasterWrappedDEK:  obj.MasterWrappedDEK,
+		DEKNonce:          obj.DEKNonce,
+		Sensitivity:       obj.Sensitivity,
+		Indexes:           indexes,
+		Version:           obj.Version,
+		CreatedAt:         obj.CreatedAt,
+		UpdatedAt:         obj.UpdatedAt,
+		ReplacedAt:        replacedAt,
+	}
+	if !expiresAt.IsZero() {
+		version.TTL = expiresAt.Unix()
+	}
+	return version
+}
+
+func GenerateHistoryPK(tenantId string, tableHash string, objectId string) string {
+	return fmt.Sprintf("TENANT#%s#TABLE#%s#HIST#%s", tenantId, tableHash, objectId)
+}
+
+func GenerateHistorySK(version int32) string {
+	return fmt.Sprintf("V#%010d", version)
+}
+
+func ParseHistorySK(sk string) (int32, error) {
+	// SK format: "V#<zero-padded version>"
+	digits, ok := strings.CutPrefix(sk, "V#")
+	if !ok {
+		return 0, fmt.Errorf("invalid history sort key %q", sk)
+	}
+	version, err := strconv.ParseInt(digits, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid history sort key %q: %w", sk, err)
+	}
+	return int32(version), nil
+}
+
+// IsExpired reports whether the version is past its retention period; DynamoDB removes expired items lazily, so readers must skip them.
+func (v *ObjectVersion) IsExpired(now time.Time) bool {
+	return v.TTL != 0 && v.TTL <= now.Unix()
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// IdempotencyRecord holds the outcome of a write request sent with an Idempotency-Key header, so that retries with the same key get the same response.
+type IdempotencyRecord struct {
+	PK string `dynamodbav:"pk" json:"pk"` // format: "TENANT#<tenant_id>#IDEMPOTENCY#<key>"
+
+	RequestHash []byte `dynamodbav:"request_hash" json:"request_hash"` // SHA-256 of the method, path and body of the first request
+	State       string `dynamodbav:"state" json:"state"`
+	LockedUntil int64  `dynamodbav:"locked_until,omitempty" json:"locked_until,omitempty"` // Unix timestamp, another request may take over an in-progress key after this time
+	LockToken   string `dynamodbav:"lock_token,omitempty" json:"lock_token,omitempty"`     // Identifies the request attempt holding an in-progress key
+
+	StatusCode  int    `dynamodbav:"status_code,omitempty" json:"status_code,omitempty"`
+	ContentType string `dynamodbav:"content_type,omitempty" json:"content_type,omitempty"`
+	Body        []byte `dynamodbav:"body,omitempty" json:"body,omitempty"`
+
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"`
+	TTL       int64     `dynamodbav:"ttl" json:"ttl"` // Unix timestamp, DynamoDB removes the record after this time
+}
+
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+func GenerateIdempotencyPK(tenantId string, key string) string {
+	return fmt.Sprintf("TENANT#%s#IDEMPOTENCY#%s", tenantId, key)
+}
@@ -3,20 +3,22 @@ package models
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
 	"strings"
 	"time"
 )
 
 type Index struct {
-	PK string `dynamodbav:"pk" json:"pk"` // format: "TENANT#<tenant_id>#TABLE#<table_hash>#IDX#<index_name>"
+	PK string `dynamodbav:"pk" json:"pk"` // format: "TENANT#<tenant_id>#TABLE#<table_hash>#IDX#<index_name>", suffixed with "#S<shard>" for sharded indexes
 	SK []byte `dynamodbav:"sk" json:"sk"` // format: "<index_token>"
 
 	GSI1PK string `dynamodbav:"gsi1pk,omitempty" json:"gsi1pk,omitempty"` // format: "TENANT#<tenant_id>#TABLE#<table_hash>#OBJ#<object_id>"
 	GSI1SK string `dynamodbav:"gsi1sk,omitempty" json:"gsi1sk,omitempty"` // format: "IDX#<index_name>"
 
-	S3Key     string    `dynamodbav:"s3_key,omitempty" json:"s3_key,omitempty"` // Duplicated S3 key for quick access (larger blobs)
-	CreatedAt time.Time `dynamodbav:"created_at,omitempty" json:"created_at"`
-	UpdatedAt time.Time `dynamodbav:"updated_at,omitempty" json:"updated_at"`
+	S3Key         string    `dynamodbav:"s3_key,omitempty" json:"s3_key,omitempty"`                 // Duplicated S3 key for quick access (larger blobs)
+	ObjectVersion int32     `dynamodbav:"object_version,omitempty" json:"object_version,omitempty"` // Version of the object the index entry was written for
+	CreatedAt     time.Time `dynamodbav:"created_at,omitempty" json:"created_at"`
+	UpdatedAt     time.Time `dynamodbav:"updated_at,omitempty" json:"updated_at"`
 }
 
 const (
@@ -32,15 +34,21 @@ var (
 	MaxRangeValue = bytes.Repeat([]byte{0xFF}, OPERangeValueLength)
 )
 
-func NewIndex(indexName string, tenantId string, tableHash string, indexToken []byte, objectId string, s3Key string) *Index {
+// NewIndex builds the index entry of an object. If shards is greater than 1, the entry is placed in the shard partition chosen by the object ID.
+func NewIndex(indexName string, tenantId string, tableHash string, indexToken []byte, objectId string, objectVersion int32, s3Key string, shards int) *Index {
+	pk := GenerateIndexPK(tenantId, tableHash, indexName)
+	if shards > 1 {
+		pk = GenerateIndexShardPK(tenantId, tableHash, indexName, IndexShardFor(objectId, shards))
+	}
 	return &Index{
-		PK:        GenerateIndexPK(tenantId, tableHash, indexName),
-		SK:        indexToken,
-		GSI1PK:    GenerateGSI1PK(tenantId, tableHash, objectId),
-		GSI1SK:    GenerateGSI1SK(indexName),
-		S3Key:     s3Key,
-		CreatedAt: time.Now().UTC(),
-		UpdatedAt: time.Now().UTC(),
+		PK:            pk,
+		SK:            indexToken,
+		GSI1PK:        GenerateGSI1PK(tenantId, tableHash, objectId),
+		GSI1SK:        GenerateGSI1SK(indexName),
+		S3Key:         s3Key,
+		ObjectVersion: objectVersion,
+		CreatedAt:     time.Now().UTC(),
+		UpdatedAt:     time.Now().UTC(),
 	}
 }
 
@@ -48,11 +56,34 @@ func GenerateIndexPK(tenantId string, tableHash string, indexName string) string
 	return fmt.Sprintf("TENANT#%s#TABLE#%s#IDX#%s", tenantId, tableHash, indexName)
 }
 
+func GenerateIndexShardPK(tenantId string, tableHash string, indexName string, shard int) string {
+	return fmt.Sprintf("%s#S%d", GenerateIndexPK(tenantId, tableHash, indexName), shard)
+}
+
+/*
+GenerateIndexPartitionPKs returns every partition key that can hold entries of an index: the unsharded partition first, then one per shard.
+The unsharded partition is always included because entries written before sharding was enabled stay there.
+*/
+func GenerateIndexPartitionPKs(tenantId string, tableHash string, indexName string, shards int) []string {
+	pks := []string{GenerateIndexPK(tenantId, tableHash, indexName)}
+	if shards > 1 {
+		for shard := range shards {
+			pks = append(pks, GenerateIndexShardPK(tenantId, tableHash, indexName, shard))
+		}
+	}
+	return pks
+}
+
+func IndexShardFor(objectId string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(objectId))
+	return int(h.Sum32() % uint32(shards))
+}
+
 func (i *Index) GetIndexName() string {
-	// PK format: "TENANT#<tenant_id>#TABLE#<table_hash>#IDX#<index_name>"
-	parts := strings.SplitN(i.PK, "#", 5)
-	if len(parts) == 5 && parts[4] == "IDX" {
-		return parts[5]
+	// GSI1SK format: "IDX#<index_name>"
+	if name, ok := strings.CutPrefix(i.GSI1SK, "IDX#"); ok {
+		return name
 	}
 	return ""
 }
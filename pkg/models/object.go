@@ -14,6 +14,12 @@ type Object struct {
 	EncryptedBlob []byte `dynamodbav:"encrypted_blob,omitempty" json:"encrypted_blob,omitempty"` // < 100KB blobs stored inline
 	S3Key         string `dynamodbav:"s3_key,omitempty" json:"s3_key,omitempty"`                 // S3 object key for larger blobs
 
+	// small client-encrypted metadata, under the object DEK with its own AAD, readable without the blob
+	EncryptedMetadata []byte `dynamodbav:"encrypted_metadata,omitempty" json:"encrypted_metadata,omitempty"`
+
+	// base64 SHA-256 of the S3 blob declared at upload, so that downloads can verify it end to end
+	ChecksumSHA256 string `dynamodbav:"checksum_sha256,omitempty" json:"checksum_sha256,omitempty"`
+
 	KMSWrappedDEK    []byte `dynamodbav:"kms_wrapped_dek,omitempty" json:"kms_wrapped_dek,omitempty"`       // DEK wrapped with KMS
 	MasterWrappedDEK []byte `dynamodbav:"master_wrapped_dek,omitempty" json:"master_wrapped_dek,omitempty"` // DEK wrapped with tenant master key
 	DEKNonce         []byte `dynamodbav:"dek_nonce,omitempty" json:"dek_nonce,omitempty"`                   // Nonce used for master_wrapped_dek
@@ -25,6 +31,17 @@ type Object struct {
 	Version   int32     `dynamodbav:"version,omitempty" json:"version,omitempty"`
 	Status    string    `dynamodbav:"status,omitempty" json:"status,omitempty"` // "pending", "ready", "deleted"
 	TTL       int64     `dynamodbav:"ttl,omitempty" json:"ttl,omitempty"`       // Unix timestamp for expiration
+
+	// Unix timestamp set by the writer, the object is gone once it is reached even if the item has not been reaped yet
+	ExpiresAt int64 `dynamodbav:"expires_at,omitempty" json:"expires_at,omitempty"`
+
+	// index entries of a soft-deleted object at the time of deletion, reinstated when it is recovered
+	DeletedIndexes []VersionIndex `dynamodbav:"deleted_indexes,omitempty" json:"deleted_indexes,omitempty"`
+
+	// set while a staged write is in progress: the index entries the object is left with if the write is interrupted,
+	// and the Unix timestamp after which the outbox worker settles it
+	StagedIndexes []VersionIndex `dynamodbav:"staged_indexes,omitempty" json:"staged_indexes,omitempty"`
+	StagedUntil   int64          `dynamodbav:"staged_until,omitempty" json:"staged_until,omitempty"`
 }
 
 const (
@@ -92,3 +109,15 @@ func (o *Object) GetTenantID() string {
 	}
 	return ""
 }
+
+// SetExpiry sets the Unix time at which the object expires, zero for never.
+// The DynamoDB TTL follows it, so the item is reaped even if the expiry task does not run.
+func (o *Object) SetExpiry(expiresAt int64) {
+	o.ExpiresAt = expiresAt
+	o.TTL = expiresAt
+}
+
+// IsExpired reports whether the object has expired at now.
+func (o *Object) IsExpired(now time.Time) bool {
+	return o.ExpiresAt != 0 && o.ExpiresAt <= now.Unix()
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// OutboxTask is a durable unit of background work, written in the same transaction as the change that requires it and processed by the API server outbox worker.
+type OutboxTask struct {
+	PK string `dynamodbav:"pk" json:"pk"` // format: "OUTBOX#<shard>"
+	SK string `dynamodbav:"sk" json:"sk"` // format: "TASK#<task_id>"
+
+	Type          string `dynamodbav:"type" json:"type"`
+	TenantID      string `dynamodbav:"tenant_id" json:"tenant_id"`
+	TableHash     string `dynamodbav:"table_hash" json:"table_hash"`
+	ObjectID      string `dynamodbav:"object_id" json:"object_id"`
+	ObjectVersion int32  `dynamodbav:"object_version,omitempty" json:"object_version,omitempty"` // Version of the object when the task was enqueued
+
+	Attempts      int    `dynamodbav:"attempts" json:"attempts"`
+	NextAttemptAt int64  `dynamodbav:"next_attempt_at" json:"next_attempt_at"`             // Unix timestamp, the task is not processed before this time; gsi1 sort key
+	LeaseUntil    int64  `dynamodbav:"lease_until,omitempty" json:"lease_until,omitempty"` // Unix timestamp, set while a worker is processing the task
+	LastError     string `dynamodbav:"last_error,omitempty" json:"last_error,omitempty"`
+	ScheduledAt   int64  `dynamodbav:"scheduled_at,omitempty" json:"scheduled_at,omitempty"` // Unix timestamp the task was first due at, later than its creation for scheduled tasks
+
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"`
+}
+
+const (
+	OutboxTaskDeleteIndexes = "delete_indexes" // remove the index entries of a soft-deleted object
+	OutboxTaskExpireObject  = "expire_object"  // remove an object version that reached its expiry, with its index entries, history and blobs
+	OutboxTaskSweepUpload   = "sweep_upload"   // remove the blob and reservation of a large object upload that was not confirmed in time
+	OutboxTaskSettleStaged  = "settle_staged"  // finish a staged object write whose writer stopped before committing it
+	OutboxTaskPurgeDeleted  = "purge_deleted"  // remove a soft-deleted object that left the trash, with its index entries, history and blobs
+)
+
+// Number of outbox partitions, tasks are spread across them by object ID
+const OutboxShards = 8
+
+func NewOutboxTask(taskId string, taskType string, tenantId string, tableHash string, objectId string, objectVersion int32, runAt time.Time) *OutboxTask {
+	return &OutboxTask{
+		PK:            GenerateOutboxPK(OutboxShardFor(objectId)),
+		SK:            GenerateOutboxSK(taskId),
+		Type:          taskType,
+		TenantID:      tenantId,
+		TableHash:     tableHash,
+		ObjectID:      objectId,
+		ObjectVersion: objectVersion,
+		NextAttemptAt: runAt.Unix(),
+		ScheduledAt:   runAt.Unix(),
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+func OutboxShardFor(objectId string) int {
+	h := fnv.New32a()
+	h.Write([]byte(objectId))
+	return int(h.Sum32() % OutboxShards)
+}
+
+func GenerateOutboxPK(shard int) string {
+	return fmt.Sprintf("OUTBOX#%d", shard)
+}
+
+func GenerateOutboxSK(taskId string) string {
+	return fmt.Sprintf("TASK#%s", taskId)
+}
+
+func (t *OutboxTask) GetTaskID() string {
+	// SK format: "TASK#<task_id>"
+	if id, ok := strings.CutPrefix(t.SK, "TASK#"); ok {
+		return id
+	}
+	return ""
+}
+
+// DueSince returns the time the task first became due, tasks enqueued before scheduling existed were due when created.
+func (t *OutboxTask) DueSince() time.Time {
+	if t.ScheduledAt == 0 {
+		return t.CreatedAt
+	}
+	return time.Unix(t.ScheduledAt, 0)
+}
@@ -12,6 +12,16 @@ type TableConfig struct {
 	// index encryption key
 	KMSWrappedIEK []byte `dynamodbav:"wrapped_iek" json:"kms_wrapped_iek"`
 
+	// number of write shards per index name, indexes not listed use a single partition
+	IndexShards map[string]int `dynamodbav:"index_shards,omitempty" json:"index_shards,omitempty"`
+
+	// history retention: past versions are kept while they are among the last HistoryMaxVersions and younger than HistoryMaxAgeDays; a zero limit is not enforced, both zero disables history
+	HistoryMaxVersions int `dynamodbav:"history_max_versions,omitempty" json:"history_max_versions,omitempty"`
+	HistoryMaxAgeDays  int `dynamodbav:"history_max_age_days,omitempty" json:"history_max_age_days,omitempty"`
+
+	// overrides the tenant's soft-delete retention for this table, nil to inherit it
+	SoftDeleteRetentionDays *int `dynamodbav:"soft_delete_retention_days,omitempty" json:"soft_delete_retention_days,omitempty"`
+
 	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"`
 	UpdatedAt time.Time `dynamodbav:"updated_at" json:"updated_at"`
 }
@@ -37,3 +47,8 @@ func (t *TableConfig) GetTenantId() string {
 	}
 	return ""
 }
+
+// KeepsHistory reports whether past versions of the table's objects are retained.
+func (t *TableConfig) KeepsHistory() bool {
+	return t.HistoryMaxVersions > 0 || t.HistoryMaxAgeDays > 0
+}
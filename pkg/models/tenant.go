@@ -14,10 +14,19 @@ type TenantConfig struct {
 	// Key metadata
 	MasterKeyVersion int `dynamodbav:"master_key_version" json:"master_key_version"`
 
+	// days a soft-deleted object stays recoverable before it is purged, nil for DefaultSoftDeleteRetentionDays
+	SoftDeleteRetentionDays *int `dynamodbav:"soft_delete_retention_days,omitempty" json:"soft_delete_retention_days,omitempty"`
+
 	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"`
 	UpdatedAt time.Time `dynamodbav:"updated_at" json:"updated_at"`
 }
 
+// Soft-delete retention bounds, in days. Zero purges deleted objects immediately.
+const (
+	DefaultSoftDeleteRetentionDays = 30
+	MaxSoftDeleteRetentionDays     = 3650
+)
+
 func NewTenantConfig(tenantID string, wrappedMasterKKey []byte, wrappedTableSalt []byte, masterKeyVersion int) *TenantConfig {
 	return &TenantConfig{
 		PK:               GenerateTenantConfigPK(tenantID),
@@ -32,3 +41,15 @@ func NewTenantConfig(tenantID string, wrappedMasterKKey []byte, wrappedTableSalt
 func GenerateTenantConfigPK(tenantID string) string {
 	return "TENANT#" + tenantID
 }
+
+// SoftDeleteRetentionDays returns the soft-delete retention that applies to a table: the table override if set, else the tenant setting, else the default.
+// Either config may be nil.
+func SoftDeleteRetentionDays(tenant *TenantConfig, table *TableConfig) int {
+	if table != nil && table.SoftDeleteRetentionDays != nil {
+		return *table.SoftDeleteRetentionDays
+	}
+	if tenant != nil && tenant.SoftDeleteRetentionDays != nil {
+		return *tenant.SoftDeleteRetentionDays
+	}
+	return DefaultSoftDeleteRetentionDays
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// UploadReservation records a presigned large object upload until it is confirmed, so that blobs uploaded but never confirmed can be swept.
+type UploadReservation struct {
+	PK string `dynamodbav:"pk" json:"pk"` // format: "TENANT#<tenant_id>#TABLE#<table_hash>#UPLOAD#<object_id>"
+	SK string `dynamodbav:"sk" json:"sk"` // format: "V#<zero-padded version>"
+
+	TenantID  string `dynamodbav:"tenant_id" json:"tenant_id"`
+	TableHash string `dynamodbav:"table_hash" json:"table_hash"`
+	ObjectID  string `dynamodbav:"object_id" json:"object_id"`
+	Version   int32  `dynamodbav:"version" json:"version"`
+	S3Key     string `dynamodbav:"s3_key" json:"s3_key"`
+	BlobSize  int64  `dynamodbav:"blob_size" json:"blob_size"` // Size announced by the client, signed into the upload URLs
+	Status    string `dynamodbav:"status" json:"status"`       // always StatusPending, confirmed reservations are deleted
+
+	// base64 SHA-256 of the blob announced by the client, signed into single-part upload URLs
+	ChecksumSHA256 string `dynamodbav:"checksum_sha256,omitempty" json:"checksum_sha256,omitempty"`
+
+	// set for multipart uploads only
+	UploadID string `dynamodbav:"upload_id,omitempty" json:"upload_id,omitempty"` // S3 multipart upload ID
+	PartSize int64  `dynamodbav:"part_size,omitempty" json:"part_size,omitempty"` // Size of every part but the last
+
+	ExpiresAt int64     `dynamodbav:"expires_at" json:"expires_at"` // Unix timestamp, the upload must be confirmed before it
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"`
+}
+
+func NewUploadReservation(tenantId string, tableHash string, objectId string, version int32, s3Key string, blobSize int64, expiresAt time.Time) *UploadReservation {
+	return &UploadReservation{
+		PK:        GenerateUploadPK(tenantId, tableHash, objectId),
+		SK:        GenerateUploadSK(version),
+		TenantID:  tenantId,
+		TableHash: tableHash,
+		ObjectID:  objectId,
+		Version:   version,
+		S3Key:     s3Key,
+		BlobSize:  blobSize,
+		Status:    StatusPending,
+		ExpiresAt: expiresAt.Unix(),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func GenerateUploadPK(tenantId string, tableHash string, objectId string) string {
+	return fmt.Sprintf("TENANT#%s#TABLE#%s#UPLOAD#%s", tenantId, tableHash, objectId)
+}
+
+func GenerateUploadSK(version int32) string {
+	return fmt.Sprintf("V#%010d", version)
+}
+
+// PartCount returns the number of parts of a multipart upload, 0 for a single-part upload.
+func (r *UploadReservation) PartCount() int32 {
+	if r.UploadID == "" || r.PartSize <= 0 {
+		return 0
+	}
+	return int32((r.BlobSize + r.PartSize - 1) / r.PartSize)
+}
+
+// PartLength returns the size of a part of a multipart upload: the part size, except for the last part which holds the remainder.
+func (r *UploadReservation) PartLength(partNumber int32) int64 {
+	return min(r.PartSize, r.BlobSize-int64(partNumber-1)*r.PartSize)
+}
+
+// IsExpired reports whether the reservation can no longer be confirmed at now.
+func (r *UploadReservation) IsExpired(now time.Time) bool {
+	return r.ExpiresAt <= now.Unix()
+}